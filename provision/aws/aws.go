@@ -7,10 +7,16 @@ import (
 	"html/template"
 	"os"
 	"strconv"
+	"time"
 
 	"strings"
 
+	"github.com/apprenda/kismatic-provision/provision/bootstrap"
+	"github.com/apprenda/kismatic-provision/provision/install"
 	"github.com/apprenda/kismatic-provision/provision/plan"
+	"github.com/apprenda/kismatic-provision/provision/remote"
+	"github.com/apprenda/kismatic-provision/provision/userdata"
+	"github.com/apprenda/kismatic-provision/provision/validate"
 	"github.com/spf13/cobra"
 )
 
@@ -19,31 +25,61 @@ type AWSOpts struct {
 	MasterNodeCount uint16
 	WorkerNodeCount uint16
 	LeaveArtifacts  bool
-	RunKismatic     bool
 	NoPlan          bool
 	ForceProvision  bool
 	KeyPairName     string
 	InstanceType    string
 	OS              string
 	Storage         bool
+	Hooks           remote.HookOpts
+	ClusterName     string
+	UserData        userdata.Opts
+	BootstrapNode   bool
+	Bootstrap       bootstrap.Opts
+	Install         install.Opts
+	Validate        validate.Opts
+	SecurityGroups  SecurityGroupOpts
+	MasterLB        bool
+	Private         bool
+	Bastion         string
+	BootstrapSubnet string
+	Subnets         []string
+	Network         NetworkOpts
+	Spot            SpotOpts
+	Volumes         VolumeOpts
+	CloudProvider   bool
+	Profiles        InstanceProfileOpts
+	HostedZone      string
+	ImportPublicKey string
+	Hardening       HardeningOpts
+	Cost            CostOpts
 }
 
 func Cmd() *cobra.Command {
+	var profile, assumeRoleARN string
 	cmd := &cobra.Command{
 		Use:   "aws",
 		Short: "Provision infrastructure on AWS.",
 		Long: `Provision infrastructure on AWS.
 		
 In addition to the commands below, AWS relies on some environment variables and conventions:
-Required:
-  AWS_ACCESS_KEY_ID: [Required] Your AWS access key, required for all operations
-  AWS_SECRET_ACCESS_KEY: [Required] Your AWS secret key, required for all operations
+Credentials: (The first found is used)
+  AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN: Your AWS access key
+  AWS_PROFILE or --profile: A profile of the shared credentials and config files in ~/.aws, 
+                            which may assume a role itself
+  The role of the EC2 instance or ECS task running the provision tool
+  AWS_ASSUME_ROLE_ARN or --assume-role-arn: [Optional] A role to assume with the credentials above
 
 Conditional: (These may be omitted if the -f flag is used)
   AWS_SUBNET_ID: The ID of a subnet to try to place machines into. If this environment variable exists, 
-                 it must be a real subnet in the us-east-1 region or all commands will fail.
+                 it must be a real subnet in the us-east-1 region or all commands will fail. Several 
+                 subnets of the same VPC can be given, separated by commas, to spread the nodes 
+                 across their availability zones.
   AWS_SECURITY_GROUP_ID: The ID of a security group to place all new machines in. Must be a part of the 
                          above subnet or commands will fail.
+  AWS_ETCD_SECURITY_GROUP_ID, AWS_MASTER_SECURITY_GROUP_ID, AWS_WORKER_SECURITY_GROUP_ID, 
+  AWS_INGRESS_SECURITY_GROUP_ID: [Optional] The IDs of additional security groups for the nodes of 
+                                 each role. The first worker is the ingress node.
   AWS_KEY_NAME: The name of a Keypair in AWS to be used to create machines. If empty, we will attempt 
                 to use a key named 'kismatic-integration-testing' and fail if it does not exist.
   AWS_SSH_KEY_PATH: The absolute path to the private key associated with the Key Name above. If left blank,
@@ -51,11 +87,24 @@ Conditional: (These may be omitted if the -f flag is used)
 					provision tool. This key is important as part of provisioning is ensuring that your
 					instance is online and is able to be reached via SSH.
 `,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			// The credentials options are passed on to the client through the environment
+			if profile != "" {
+				os.Setenv("AWS_PROFILE", profile)
+			}
+			if assumeRoleARN != "" {
+				os.Setenv("AWS_ASSUME_ROLE_ARN", assumeRoleARN)
+			}
+		},
 	}
 
+	cmd.PersistentFlags().StringVar(&profile, "profile", "", "Profile of the shared AWS credentials and config files to use.")
+	cmd.PersistentFlags().StringVar(&assumeRoleARN, "assume-role-arn", "", "ARN of a role to assume with the credentials.")
+
 	cmd.AddCommand(AWSCreateCmd())
 	cmd.AddCommand(AWSCreateMinikubeCmd())
 	cmd.AddCommand(AWSDeleteCmd())
+	cmd.AddCommand(AWSSSHCmd())
 
 	return cmd
 }
@@ -69,8 +118,9 @@ func AWSCreateCmd() *cobra.Command {
 		
 For now, only the US East region is supported.
 
-Smallish instances will be created with public IP addresses. The command will not return until the instances are all online and accessible via SSH.`,
+Smallish instances will be created with public IP addresses, unless --private is used. The command will not return until the instances are all online and accessible via SSH.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			opts.Install.SetRelease(opts.Bootstrap)
 			return makeInfra(opts)
 		},
 	}
@@ -79,10 +129,28 @@ Smallish instances will be created with public IP addresses. The command will no
 	cmd.Flags().Uint16VarP(&opts.MasterNodeCount, "masterdNodeCount", "m", 1, "Count of master nodes to produce.")
 	cmd.Flags().Uint16VarP(&opts.WorkerNodeCount, "workerNodeCount", "w", 1, "Count of worker nodes to produce.")
 	cmd.Flags().BoolVarP(&opts.NoPlan, "noplan", "n", false, "If present, foregoes generating a plan file in this directory referencing the newly created nodes")
-	cmd.Flags().BoolVarP(&opts.ForceProvision, "force-provision", "f", false, "If present, generate anything needed to build a cluster including VPCs, keypairs, routes, subnets, & a very insecure security group.")
+	cmd.Flags().BoolVarP(&opts.ForceProvision, "force-provision", "f", false, "If present, generate anything needed to build a cluster including VPCs, keypairs, routes, subnets, & a security group per role.")
+	addSecurityGroupFlags(cmd, &opts.SecurityGroups)
 	cmd.Flags().StringVarP(&opts.InstanceType, "instance-type-blueprint", "i", "small", "A blueprint of instance type(s). Current options: micro (all t2 micros), small (t2 micros, workers are t2.medium), beefy (M4.large and xlarge)")
 	cmd.Flags().StringVarP(&opts.OS, "operating-system", "o", "ubuntu", "Which flavor of Linux to provision. Try ubuntu, centos or rhel.")
 	cmd.Flags().BoolVarP(&opts.Storage, "storage-cluster", "s", false, "Create a storage cluster from all Worker nodes.")
+	cmd.Flags().BoolVar(&opts.MasterLB, "master-lb", false, "Create a network load balancer in front of the master nodes, and use it as the load balancer of the plan.")
+	addNetworkFlags(cmd, &opts)
+	addKeypairFlags(cmd, &opts.ImportPublicKey)
+	addHardeningFlags(cmd, &opts.Hardening)
+	addCostFlags(cmd, &opts.Cost)
+	addSpotFlags(cmd, &opts.Spot)
+	addVolumeFlags(cmd, &opts.Volumes)
+	cmd.Flags().BoolVar(&opts.CloudProvider, "cloud-provider", false, "Set up the AWS cloud provider of Kubernetes: give the nodes an IAM instance profile with the permissions it needs, and tag the instances, subnets and cluster security group with the cluster name.")
+	addInstanceProfileFlags(cmd, &opts.Profiles)
+	cmd.Flags().StringVar(&opts.HostedZone, "hosted-zone", "", "Domain name of a Route53 hosted zone in which to create a record for each node, named <role><n>.<cluster-name>.<zone>, and an api.<cluster-name>.<zone> record for the API server. The records are used as the hosts of the plan.")
+	cmd.Flags().BoolVar(&opts.BootstrapNode, "bootstrap", false, "Create a bootstrap node with KET and kubectl installed, from which the cluster can be installed and managed.")
+	bootstrap.AddFlags(cmd, &opts.Bootstrap)
+	cmd.Flags().StringVar(&opts.ClusterName, "cluster-name", "kismatic", "Name of the cluster, made available to user data templates.")
+	userdata.AddFlags(cmd, &opts.UserData)
+	remote.AddHookFlags(cmd, &opts.Hooks)
+	validate.AddFlags(cmd, &opts.Validate)
+	install.AddFlags(cmd, &opts.Install)
 
 	return cmd
 }
@@ -104,13 +172,33 @@ A smallish instance will be created with public IP addresses. The command will n
 
 	cmd.Flags().StringVarP(&opts.OS, "operating-system", "o", "ubuntu", "Which flavor of Linux to provision. Try ubuntu, centos or rhel.")
 	cmd.Flags().BoolVarP(&opts.NoPlan, "noplan", "n", false, "If present, foregoes generating a plan file in this directory referencing the newly created nodes")
-	cmd.Flags().BoolVarP(&opts.ForceProvision, "force-provision", "f", false, "If present, generate anything needed to build a cluster including VPCs, keypairs, routes, subnets, & a very insecure security group.")
+	cmd.Flags().BoolVarP(&opts.ForceProvision, "force-provision", "f", false, "If present, generate anything needed to build a cluster including VPCs, keypairs, routes, subnets, & a security group per role.")
+	addSecurityGroupFlags(cmd, &opts.SecurityGroups)
 	cmd.Flags().StringVarP(&opts.InstanceType, "instance-type-blueprint", "i", "small", "A blueprint of instance type(s). Current options: micro (all t2 micros), small (t2 micros, workers are t2.medium), beefy (M4.large and xlarge)")
 	cmd.Flags().BoolVarP(&opts.Storage, "storage-cluster", "s", false, "Create a storage cluster from all Worker nodes.")
+	addNetworkFlags(cmd, &opts)
+	addKeypairFlags(cmd, &opts.ImportPublicKey)
+	addHardeningFlags(cmd, &opts.Hardening)
+	addCostFlags(cmd, &opts.Cost)
+	addVolumeFlags(cmd, &opts.Volumes)
+	cmd.Flags().BoolVar(&opts.CloudProvider, "cloud-provider", false, "Set up the AWS cloud provider of Kubernetes: give the nodes an IAM instance profile with the permissions it needs, and tag the instances, subnets and cluster security group with the cluster name.")
+	cmd.Flags().StringVar(&opts.ClusterName, "cluster-name", "kismatic", "Name of the cluster, made available to user data templates.")
+	userdata.AddFlags(cmd, &opts.UserData)
+	remote.AddHookFlags(cmd, &opts.Hooks)
+	validate.AddFlags(cmd, &opts.Validate)
+	install.AddFlags(cmd, &opts.Install)
 
 	return cmd
 }
 
+func addNetworkFlags(cmd *cobra.Command, opts *AWSOpts) {
+	cmd.Flags().StringSliceVar(&opts.Subnets, "subnets", []string{}, "IDs of the subnets to place the nodes into, overriding AWS_SUBNET_ID. The nodes of each role are spread round-robin across the availability zones of the subnets.")
+	addNetworkCIDRFlags(cmd, &opts.Network)
+	cmd.Flags().BoolVar(&opts.Private, "private", false, "Create the nodes without public IPs, and use their private IPs in the plan. The subnet must reach the internet through a NAT gateway.")
+	cmd.Flags().StringVar(&opts.Bastion, "bastion", "", "Host, optionally prefixed with a user, through which private nodes are reached over SSH. Defaults to the bootstrap node, if it was launched with --bootstrap-subnet. Leave empty when running from within the VPC.")
+	cmd.Flags().StringVar(&opts.BootstrapSubnet, "bootstrap-subnet", "", "ID of a public subnet of the VPC to launch the bootstrap node into with --private, giving it a public IP so that it can be used as the bastion.")
+}
+
 func AWSDeleteCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "delete-all",
@@ -130,18 +218,40 @@ Be ready.`,
 	return cmd
 }
 
-func checkAWSCredentials() error {
-	c := CompositeError{}
-	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
-	if accessKeyID == "" {
-		c.add(errors.New("Need AWS_ACCESS_KEY_ID env variable set to perform any AWS operations"))
-	}
-	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
-	if secretAccessKey == "" {
-		c.add(errors.New("Need AWS_SECRET_ACCESS_KEY env variable set to perform any AWS operations"))
+func AWSSSHCmd() *cobra.Command {
+	var timeout time.Duration
+	var bastion string
+	cmd := &cobra.Command{
+		Use:   "ssh HOSTNAME|ROLE|ROLE[INDEX] [-- COMMAND]",
+		Short: "Connects to a node provisioned by this tool from this machine using SSH.",
+		Long: `Connects to a node provisioned by this tool from this machine using SSH.
+
+Nodes can be selected by hostname, public or private IP, or by role and index (e.g. master[0]). 
+When a role is given without an index, the command is run on all the nodes of that role in parallel.`,
+		Example: `# Open a shell on the first master node
+provision aws ssh master[0]
+
+# Check the uptime of all worker nodes
+provision aws ssh worker -- uptime`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			selector, command, err := remote.SplitArgs(cmd, args)
+			if err != nil {
+				return err
+			}
+			return sshInfra(selector, command, bastion, timeout)
+		},
 	}
-	if c.hasError() {
-		return c
+
+	cmd.Flags().DurationVar(&timeout, "timeout", 10*time.Minute, "Maximum time to wait for a command to complete on all nodes.")
+	cmd.Flags().StringVar(&bastion, "bastion", "", "Host, optionally prefixed with a user, through which nodes without a public IP are reached. Defaults to the bootstrap node, if any.")
+
+	return cmd
+}
+
+// checkAWSCredentials makes sure credentials can be found, and the role assumed if one was given
+func checkAWSCredentials(awsClient *awsProvisioner) error {
+	if _, err := awsClient.client.getSession(); err != nil {
+		return fmt.Errorf("%v\nSet AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, use --profile, or run on an instance with a role to perform any AWS operations", err)
 	}
 	return nil
 }
@@ -189,28 +299,65 @@ func checkAWSDeploymentEnvironment() error {
 }
 
 func deleteInfra() error {
-	if err := checkAWSCredentials(); err != nil {
+	awsClient := AWSClientFromEnvironment()
+	if err := checkAWSCredentials(awsClient); err != nil {
 		return err
 	}
 
-	awsClient, _ := AWSClientFromEnvironment()
+	if err := awsClient.client.DeleteLoadBalancers(); err != nil {
+		return err
+	}
+	if err := awsClient.client.CancelSpotRequests(); err != nil {
+		return err
+	}
+	// The records are found through the tags of the instances
+	if err := awsClient.client.DeleteRecords(); err != nil {
+		return err
+	}
+	if err := awsClient.TerminateAllNodes(); err != nil {
+		return err
+	}
+	return awsClient.client.DeleteInstanceProfiles()
+}
+
+func sshInfra(selector string, command []string, bastion string, timeout time.Duration) error {
+	awsClient := AWSClientFromEnvironment()
+	if err := checkAWSCredentials(awsClient); err != nil {
+		return err
+	}
 
-	return awsClient.TerminateAllNodes()
+	cluster, err := awsClient.Cluster(bastion)
+	if err != nil {
+		return err
+	}
+
+	return remote.SSH(cluster, selector, command, awsClient.SSHKey(), timeout)
 }
 
-func prepareToModifyAWS(forceProvision bool) error {
-	if err := checkAWSCredentials(); err != nil {
+func prepareToModifyAWS(opts AWSOpts, awsClient *awsProvisioner) error {
+	if err := checkAWSCredentials(awsClient); err != nil {
 		return err
 	}
 
-	awsClient, _ := AWSClientFromEnvironment()
+	if len(opts.Subnets) > 0 {
+		os.Setenv("AWS_SUBNET_ID", strings.Join(opts.Subnets, ","))
+		awsClient.configFromEnvironment()
+	}
 
 	fmt.Printf("Using region %v\n", awsClient.client.Config.Region)
 
-	if forceProvision {
-		if err := awsClient.ForceProvision(); err != nil {
+	if opts.ImportPublicKey != "" {
+		if err := awsClient.client.ImportKeypair(opts.ImportPublicKey, awsClient.sshKey); err != nil {
+			return err
+		}
+	}
+
+	if opts.ForceProvision {
+		if err := awsClient.ForceProvision(opts.Network, opts.SecurityGroups); err != nil {
 			return err
 		}
+		// The subnets and security groups are passed on through the environment
+		awsClient.configFromEnvironment()
 	}
 
 	if err := checkAWSDeploymentEnvironment(); err != nil {
@@ -227,7 +374,7 @@ func prepareToModifyAWS(forceProvision bool) error {
 		return fmt.Errorf("Set permissions of %v to 0600", awsClient.sshKey)
 	}
 
-	return nil
+	return awsClient.client.CheckKeypair(awsClient.sshKey)
 }
 
 func assertOptions(opts AWSOpts) (NodeBlueprint, LinuxDistro, error) {
@@ -235,7 +382,49 @@ func assertOptions(opts AWSOpts) (NodeBlueprint, LinuxDistro, error) {
 	if !ok {
 		return NodeBlueprint{}, "", fmt.Errorf("%v is not valid option for instance type blueprint.", opts.InstanceType)
 	}
-	if err := prepareToModifyAWS(opts.ForceProvision); err != nil {
+	if _, err := opts.Hooks.Hooks(); err != nil {
+		return NodeBlueprint{}, "", err
+	}
+	if opts.Install.Enabled && opts.NoPlan {
+		return NodeBlueprint{}, "", errors.New("--install cannot be used with --noplan")
+	}
+	// The install is run from the bootstrap node when there is one
+	if !opts.BootstrapNode {
+		if err := opts.Install.Validate(); err != nil {
+			return NodeBlueprint{}, "", err
+		}
+	}
+	if opts.Bastion != "" && !opts.Private {
+		return NodeBlueprint{}, "", errors.New("--bastion can only be used with --private")
+	}
+	if opts.Private && opts.ForceProvision && len(opts.Subnets) == 0 {
+		return NodeBlueprint{}, "", errors.New("--private cannot be used with --force-provision without --subnets, the subnets it creates have no NAT gateway")
+	}
+	if opts.BootstrapSubnet != "" && (!opts.Private || !opts.BootstrapNode) {
+		return NodeBlueprint{}, "", errors.New("--bootstrap-subnet can only be used with --private and --bootstrap")
+	}
+	if opts.Bastion != "" && opts.BootstrapSubnet != "" {
+		return NodeBlueprint{}, "", errors.New("--bastion cannot be used with --bootstrap-subnet, the bootstrap node is the bastion")
+	}
+	// kismatic cannot go through a bastion, so it has to be run from within the VPC
+	if opts.Private && opts.Install.Enabled && !opts.BootstrapNode && opts.Bastion != "" {
+		return NodeBlueprint{}, "", errors.New("--install cannot reach private nodes through --bastion, use --bootstrap to install from within the VPC")
+	}
+	if err := opts.UserData.Validate(); err != nil {
+		return NodeBlueprint{}, "", err
+	}
+	if opts.BootstrapNode {
+		if err := opts.Bootstrap.Validate(); err != nil {
+			return NodeBlueprint{}, "", err
+		}
+	}
+	if err := opts.Network.Validate(); err != nil {
+		return NodeBlueprint{}, "", err
+	}
+	if err := opts.Spot.Validate(); err != nil {
+		return NodeBlueprint{}, "", err
+	}
+	if err := opts.Cost.Validate(); err != nil {
 		return NodeBlueprint{}, "", err
 	}
 
@@ -254,20 +443,57 @@ func assertOptions(opts AWSOpts) (NodeBlueprint, LinuxDistro, error) {
 }
 
 func makeInfraMinikube(opts AWSOpts) error {
+	volumes, err := opts.Volumes.ParseMinikube()
+	if err != nil {
+		return err
+	}
+	dockerDevice, err := dockerBlockDevice(volumes, "worker")
+	if err != nil {
+		return err
+	}
 	blueprint, distro, err := assertOptions(opts)
 	if err != nil {
 		return err
 	}
+	count := NodeCount{Worker: 1}
+	// The client is shared, so that the credentials are only looked up once
+	awsClient := AWSClientFromEnvironment()
+	if stop, err := reviewCost(opts, awsClient, blueprint, count, volumes); stop || err != nil {
+		return err
+	}
+	if err := prepareToModifyAWS(opts, awsClient); err != nil {
+		return err
+	}
 
 	fmt.Print("Provisioning")
-	awsClient, _ := AWSClientFromEnvironment()
-	nodes, err := awsClient.ProvisionNodes(blueprint, NodeCount{
-		Worker: 1,
-	}, distro)
+	awsClient.client.Config.Private = opts.Private
+	awsClient.client.Config.BootstrapSubnetID = opts.BootstrapSubnet
+	awsClient.client.Config.Spot = opts.Spot
+	awsClient.client.Config.Volumes = volumes
+	awsClient.client.Config.Hardening = opts.Hardening
+	if opts.CloudProvider {
+		profiles := InstanceProfileOpts{Master: instanceProfileName(opts.ClusterName, "master")}
+		if err := awsClient.client.SetUpInstanceProfiles(profiles, true); err != nil {
+			return err
+		}
+		// The minikube node is a master too
+		awsClient.client.Config.InstanceProfiles["worker"] = profiles.Master
+		if err := awsClient.client.SetUpCloudProvider(opts.ClusterName); err != nil {
+			return err
+		}
+	}
+	nodes, err := awsClient.ProvisionNodes(blueprint, count, distro, opts.ClusterName, opts.UserData)
 
 	if err != nil {
 		return err
 	}
+	// Only the security groups created by -f are changed
+	if opts.ForceProvision {
+		if err := awsClient.client.AllowAPIFromNodes(nodes.allNodes()); err != nil {
+			return err
+		}
+	}
+	nodes.setBastion(opts.Bastion)
 
 	sshKey := awsClient.SSHKey()
 	fmt.Print("Waiting for SSH")
@@ -275,6 +501,14 @@ func makeInfraMinikube(opts AWSOpts) error {
 		return err
 	}
 
+	minikube := remote.Cluster{"etcd": nodes.Worker, "master": nodes.Worker, "worker": nodes.Worker}
+	if err = opts.Hooks.RunHooks(minikube, sshKey); err != nil {
+		return err
+	}
+	if err = opts.Validate.Run(minikube, sshKey); err != nil {
+		return err
+	}
+
 	if opts.NoPlan {
 		fmt.Println("Your instances are ready.\n")
 		printRole("Minikube", &nodes.Worker)
@@ -283,37 +517,106 @@ func makeInfraMinikube(opts AWSOpts) error {
 		if opts.Storage {
 			storageNodes = []plan.Node{nodes.Worker[0]}
 		}
-		return makePlan(&plan.Plan{
-			Etcd:         []plan.Node{nodes.Worker[0]},
-			Master:       []plan.Node{nodes.Worker[0]},
-			Worker:       []plan.Node{nodes.Worker[0]},
-			Ingress:      []plan.Node{nodes.Worker[0]},
-			Storage:      storageNodes,
-			LoadBalancer: nodes.Worker[0].PublicIPv4 + ":6443",
-			SSHKeyFile:   sshKey,
-			SSHUser:      nodes.Worker[0].SSHUser,
+		planFile, err := makePlan(&plan.Plan{
+			Etcd:              []plan.Node{nodes.Worker[0]},
+			Master:            []plan.Node{nodes.Worker[0]},
+			Worker:            []plan.Node{nodes.Worker[0]},
+			Ingress:           []plan.Node{nodes.Worker[0]},
+			Storage:           storageNodes,
+			LoadBalancer:      nodes.Worker[0].PublicIPv4 + ":6443",
+			SSHKeyFile:        sshKey,
+			SSHUser:           nodes.Worker[0].SSHUser,
+			DockerBlockDevice: dockerDevice,
+			CloudProvider:     cloudProvider(opts),
 		})
+		if err != nil {
+			return err
+		}
+		if opts.Install.Enabled {
+			return opts.Install.Run(planFile)
+		}
+		printInstallInstructions(planFile)
 	}
 	return nil
 }
 
 func makeInfra(opts AWSOpts) error {
+	volumes, err := opts.Volumes.Parse()
+	if err != nil {
+		return err
+	}
+	// Only the masters and workers run docker
+	dockerDevice, err := dockerBlockDevice(volumes, "master", "worker")
+	if err != nil {
+		return err
+	}
 	blueprint, distro, err := assertOptions(opts)
 	if err != nil {
 		return err
 	}
 
+	var bootCount uint16
+	if opts.BootstrapNode {
+		bootCount = 1
+	}
+	count := NodeCount{
+		Etcd:      opts.EtcdNodeCount,
+		Worker:    opts.WorkerNodeCount,
+		Master:    opts.MasterNodeCount,
+		Bootstrap: bootCount,
+	}
+	// The client is shared, so that the credentials are only looked up once
+	awsClient := AWSClientFromEnvironment()
+	if stop, err := reviewCost(opts, awsClient, blueprint, count, volumes); stop || err != nil {
+		return err
+	}
+	if err := prepareToModifyAWS(opts, awsClient); err != nil {
+		return err
+	}
+
 	fmt.Print("Provisioning")
-	awsClient, _ := AWSClientFromEnvironment()
-	nodes, err := awsClient.ProvisionNodes(blueprint, NodeCount{
-		Etcd:   opts.EtcdNodeCount,
-		Worker: opts.WorkerNodeCount,
-		Master: opts.MasterNodeCount,
-	}, distro)
+	awsClient.client.Config.Private = opts.Private
+	awsClient.client.Config.BootstrapSubnetID = opts.BootstrapSubnet
+	awsClient.client.Config.Spot = opts.Spot
+	awsClient.client.Config.Volumes = volumes
+	awsClient.client.Config.Hardening = opts.Hardening
+	profiles := opts.Profiles
+	if opts.CloudProvider {
+		profiles = profiles.withDefaults(opts.ClusterName)
+	}
+	if err := awsClient.client.SetUpInstanceProfiles(profiles, opts.ForceProvision || opts.CloudProvider); err != nil {
+		return err
+	}
+	if opts.CloudProvider {
+		if err := awsClient.client.SetUpCloudProvider(opts.ClusterName); err != nil {
+			return err
+		}
+	}
+	var zone *hostedZone
+	if opts.HostedZone != "" {
+		if zone, err = awsClient.client.LookupHostedZone(opts.HostedZone); err != nil {
+			return err
+		}
+	}
+	nodes, err := awsClient.ProvisionNodes(blueprint, count, distro, opts.ClusterName, opts.UserData)
 
 	if err != nil {
 		return err
 	}
+	// Only the security groups created by -f are changed. The nodes reach the API server
+	// through the load balancer from within the VPC when there is one.
+	if opts.ForceProvision && !opts.MasterLB {
+		if err := awsClient.client.AllowAPIFromNodes(nodes.allNodes()); err != nil {
+			return err
+		}
+	}
+	if opts.Private {
+		bastion := opts.Bastion
+		if bastion == "" && opts.BootstrapSubnet != "" {
+			bastion = nodes.Bootstrap[0].PublicIPv4
+		}
+		nodes.setBastion(bastion)
+	}
 
 	sshKey := awsClient.SSHKey()
 	fmt.Print("Waiting for SSH")
@@ -321,52 +624,155 @@ func makeInfra(opts AWSOpts) error {
 		return err
 	}
 
+	// The hooks and the validation see the nodes as they are named in the plan
+	if zone != nil {
+		names, err := awsClient.client.CreateNodeRecords(*zone, opts.ClusterName, nodes)
+		if err != nil {
+			return err
+		}
+		// The AWS cloud provider expects the nodes to be named after their private DNS name
+		if !opts.CloudProvider {
+			if err := useHostnames(&nodes, names, sshKey); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err = opts.Hooks.RunHooks(nodes.cluster(), sshKey); err != nil {
+		return err
+	}
+	if err = opts.Validate.Run(nodes.cluster(), sshKey); err != nil {
+		return err
+	}
+
+	loadBalancer := nodes.Master[0].PublicIPv4
+	var lbDNSName string
+	if opts.MasterLB {
+		if opts.ForceProvision {
+			if err := awsClient.client.AllowAPIFromVPC(); err != nil {
+				return err
+			}
+		}
+		dnsName, err := awsClient.client.CreateMasterLoadBalancer(opts.ClusterName, nodes.Master, opts.Private)
+		if err != nil {
+			return err
+		}
+		loadBalancer = dnsName
+		lbDNSName = dnsName
+	}
+	extraSANs := lbDNSName
+	if zone != nil {
+		apiName, err := awsClient.client.CreateAPIRecord(*zone, opts.ClusterName, nodes.Master, lbDNSName)
+		if err != nil {
+			return err
+		}
+		loadBalancer = apiName
+		extraSANs = strings.Trim(lbDNSName+","+apiName, ",")
+	}
+
 	if opts.NoPlan {
 		fmt.Println("Your instances are ready.\n")
 		printNodes(&nodes)
+		if opts.MasterLB || zone != nil {
+			fmt.Printf("Load balancer:\n  %v\n", loadBalancer)
+		}
 	} else {
 		storageNodes := []plan.Node{}
 		if opts.Storage {
 			storageNodes = nodes.Worker
 		}
 
-		return makePlan(&plan.Plan{
-			Etcd:         nodes.Etcd,
-			Master:       nodes.Master,
-			Worker:       nodes.Worker,
-			Ingress:      []plan.Node{nodes.Worker[0]},
-			Storage:      storageNodes,
-			LoadBalancer: nodes.Master[0].PublicIPv4 + ":6443",
-			SSHKeyFile:   sshKey,
-			SSHUser:      nodes.Master[0].SSHUser,
+		// If the user asks for a bootstrap node, the generated plan file will contain
+		// the path to the SSH key on the bootstrap node.
+		sshKeyFile := sshKey
+		if opts.BootstrapNode {
+			sshKeyFile = opts.Bootstrap.KeyPath(sshKey)
+		}
+
+		planFile, err := makePlan(&plan.Plan{
+			Etcd:                   nodes.Etcd,
+			Master:                 nodes.Master,
+			Worker:                 nodes.Worker,
+			Ingress:                []plan.Node{nodes.Worker[0]},
+			Storage:                storageNodes,
+			LoadBalancer:           loadBalancer + ":6443",
+			APIServerCertExtraSANs: extraSANs,
+			SSHKeyFile:             sshKeyFile,
+			SSHUser:                nodes.Master[0].SSHUser,
+			DockerBlockDevice:      dockerDevice,
+			CloudProvider:          cloudProvider(opts),
 		})
+		if err != nil {
+			return err
+		}
+
+		if opts.BootstrapNode {
+			if err := opts.Bootstrap.Prepare(nodes.Bootstrap[0], sshKey, planFile); err != nil {
+				return err
+			}
+			if opts.Install.Enabled {
+				return opts.Bootstrap.Install(nodes.Bootstrap[0], sshKey)
+			}
+			opts.Bootstrap.PrintInstructions(nodes.Bootstrap[0], sshKey)
+			return nil
+		}
+		if opts.Install.Enabled {
+			return opts.Install.Run(planFile)
+		}
+		printInstallInstructions(planFile)
+	}
+	return nil
+}
+
+// useHostnames sets the hostname of each node to the name of its DNS record, which is then
+// used as its host in the plan.
+func useHostnames(nodes *ProvisionedNodes, names map[string]string, sshKey string) error {
+	for _, role := range [][]plan.Node{nodes.Etcd, nodes.Master, nodes.Worker, nodes.Bootstrap} {
+		for i := range role {
+			name := names[role[i].ID]
+			if err := SetHostname(role[i], name, sshKey); err != nil {
+				return fmt.Errorf("error setting the hostname of %s: %v", role[i].ID, err)
+			}
+			role[i].Host = name
+		}
 	}
 	return nil
 }
 
-func makePlan(pln *plan.Plan) error {
+// cloudProvider returns the cloud provider of the plan
+func cloudProvider(opts AWSOpts) string {
+	if opts.CloudProvider {
+		return "aws"
+	}
+	return ""
+}
+
+func makePlan(pln *plan.Plan) (string, error) {
 	template, err := template.New("planAWSOverlay").Parse(plan.OverlayNetworkPlan)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	f, err := makeUniqueFile(0)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	defer f.Close()
 	w := bufio.NewWriter(f)
 
 	if err = template.Execute(w, &pln); err != nil {
-		return err
+		return "", err
 	}
 
 	w.Flush()
-	fmt.Println("To install your cluster, run:")
-	fmt.Println("./kismatic install apply -f " + f.Name())
 
-	return nil
+	return f.Name(), nil
+}
+
+func printInstallInstructions(planFile string) {
+	fmt.Println("To install your cluster, run:")
+	fmt.Println("./kismatic install apply -f " + planFile)
 }
 
 func makeUniqueFile(count int) (*os.File, error) {
@@ -387,11 +793,14 @@ func printNodes(nodes *ProvisionedNodes) {
 	printRole("Etcd", &nodes.Etcd)
 	printRole("Master", &nodes.Master)
 	printRole("Worker", &nodes.Worker)
+	if len(nodes.Bootstrap) > 0 {
+		printRole("Bootstrap", &nodes.Bootstrap)
+	}
 }
 
 func printRole(title string, nodes *[]plan.Node) {
 	fmt.Printf("%v:\n", title)
 	for _, node := range *nodes {
-		fmt.Printf("  %v (%v, %v)\n", node.ID, node.PublicIPv4, node.PrivateIPv4)
+		fmt.Printf("  %v (%v, %v) %v\n", node.ID, node.PublicIPv4, node.PrivateIPv4, node.Zone)
 	}
 }
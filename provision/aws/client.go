@@ -2,15 +2,21 @@ package aws
 
 import (
 	"bufio"
+	"encoding/base64"
 	"fmt"
 	"os"
+	"sort"
+	"strings"
 
 	"github.com/apprenda/kismatic-provision/provision/retry"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/route53"
 )
 
 const (
@@ -24,11 +30,20 @@ const (
 
 // A Node on AWS
 type Node struct {
+	ID             string
+	Role           string
 	PrivateDNSName string
 	PrivateIP      string
 	PublicIP       string
 	SSHUser        string
 	ImageID        string
+	Zone           string
+	State          string
+	// StateReason explains the state, e.g. why the instance was terminated
+	StateReason string
+	// DNSZone is the hosted zone of the DNSNames records pointing at the node
+	DNSZone  string
+	DNSNames []string
 }
 
 // AMI is the Amazon Machine Image
@@ -39,96 +54,220 @@ type InstanceType string
 
 // ClientConfig of the AWS client
 type ClientConfig struct {
-	Region          string
-	SubnetID        string
-	Keyname         string
-	SecurityGroupID string
+	Region               string
+	SubnetID             string
+	SubnetIDs            []string
+	Keyname              string
+	SecurityGroupID      string
+	RoleSecurityGroupIDs map[string]string
+	// Private clusters are made of nodes without public IPs. Only the bootstrap
+	// node gets one when it is launched into the public BootstrapSubnetID, so that
+	// it can be used as a bastion.
+	Private           bool
+	BootstrapSubnetID string
+	Spot              SpotOpts
+	// Volumes are attached to the nodes of their role, in addition to the root volume
+	Volumes []VolumeSpec
+	// InstanceProfiles are the names of the IAM instance profiles of the nodes of each role
+	InstanceProfiles map[string]string
+	// ClusterTag is set on the instances for the AWS cloud provider, when not empty
+	ClusterTag string
+	Hardening  HardeningOpts
 }
 
-// Credentials to be used for accessing the AI
+// hasPublicIP returns whether the nodes of the role get a public IP
+func (c ClientConfig) hasPublicIP(role string) bool {
+	return !c.Private || (role == "bootstrap" && c.BootstrapSubnetID != "")
+}
+
+// Credentials to be used for accessing the API. The credentials are looked up with the
+// default chain of the SDK: the environment, the shared config files using the profile,
+// and the role of the EC2 instance or ECS task. The role, if any, is then assumed.
 type Credentials struct {
-	ID     string
-	Secret string
+	Profile       string
+	AssumeRoleARN string
 }
 
 // Client for provisioning machines on AWS
 type Client struct {
 	Config      *ClientConfig
 	Credentials Credentials
+	session     *session.Session
 	ec2Client   *ec2.EC2
+	elbClient   *elbv2.ELBV2
+	iamClient   *iam.IAM
+	dnsClient   *route53.Route53
+}
+
+func (c *Client) getSession() (*session.Session, error) {
+	if c.session == nil {
+		sess, err := session.NewSessionWithOptions(session.Options{
+			Config:                  *aws.NewConfig().WithRegion(c.Config.Region).WithMaxRetries(10),
+			Profile:                 c.Credentials.Profile,
+			SharedConfigState:       session.SharedConfigEnable,
+			AssumeRoleTokenProvider: stscreds.StdinTokenProvider,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Error with credentials provided: %v", err)
+		}
+		if c.Credentials.AssumeRoleARN != "" {
+			sess = sess.Copy(&aws.Config{Credentials: stscreds.NewCredentials(sess, c.Credentials.AssumeRoleARN)})
+		}
+		if _, err := sess.Config.Credentials.Get(); err != nil {
+			return nil, fmt.Errorf("Error with credentials provided: %v", err)
+		}
+		c.session = sess
+	}
+	return c.session, nil
 }
 
 func (c *Client) getAPIClient() (*ec2.EC2, error) {
 	if c.ec2Client == nil {
-		creds := credentials.NewStaticCredentials(c.Credentials.ID, c.Credentials.Secret, "")
-		_, err := creds.Get()
+		sess, err := c.getSession()
 		if err != nil {
-			return nil, fmt.Errorf("Error with credentials provided: %v", err)
+			return nil, err
 		}
-		config := aws.NewConfig().WithRegion(c.Config.Region).WithCredentials(creds).WithMaxRetries(10)
-		c.ec2Client = ec2.New(session.New(config))
+		c.ec2Client = ec2.New(sess)
 	}
 	return c.ec2Client, nil
 }
 
-// CreateNode is for creating a machine on AWS using the given AMI and InstanceType.
-// Returns the ID of the newly created machine.
-func (c Client) CreateNode(ami AMI, instanceType InstanceType, size int64) (string, error) {
+func (c *Client) getELBClient() (*elbv2.ELBV2, error) {
+	if c.elbClient == nil {
+		sess, err := c.getSession()
+		if err != nil {
+			return nil, err
+		}
+		c.elbClient = elbv2.New(sess)
+	}
+	return c.elbClient, nil
+}
+
+// NodeSpec is what sets a node apart from the other nodes of its role
+type NodeSpec struct {
+	UserData       string
+	Subnet         string
+	SecurityGroups []string
+}
+
+// CreateNodes is for creating the machines of a role on AWS using the given AMI and InstanceType.
+// The machines are tagged with the role they will play in the cluster when they are launched, are
+// placed in the subnet and the security groups of their spec, and receive the user data if it is
+// not empty. Machines with the same spec are launched by a single request. When a request fails,
+// the machines launched by the previous requests are destroyed too.
+// Returns the IDs of the newly created machines, in the order of the specs.
+func (c Client) CreateNodes(ami AMI, instanceType InstanceType, size int64, role string, specs []NodeSpec) ([]string, error) {
 	api, err := c.getAPIClient()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	req := &ec2.RunInstancesInput{
-		ImageId: aws.String(string(ami)),
-		BlockDeviceMappings: []*ec2.BlockDeviceMapping{
-			{
-				DeviceName: aws.String("/dev/sda1"),
-				Ebs: &ec2.EbsBlockDevice{
-					DeleteOnTermination: aws.Bool(true),
-					VolumeSize:          aws.Int64(size),
-				},
-			},
-		},
-		InstanceType: aws.String(string(instanceType)),
-		MinCount:     aws.Int64(1),
-		MaxCount:     aws.Int64(1),
-		KeyName:      aws.String(c.Config.Keyname),
-		NetworkInterfaces: []*ec2.InstanceNetworkInterfaceSpecification{
-			&ec2.InstanceNetworkInterfaceSpecification{
-				AssociatePublicIpAddress: aws.Bool(true),
-				DeviceIndex:              aws.Int64(0),
-				SubnetId:                 aws.String(c.Config.SubnetID),
-				Groups:                   []*string{aws.String(c.Config.SecurityGroupID)},
-			},
-		},
+
+	// Group the nodes that can be launched together, keeping the order of the specs
+	batches := [][]int{}
+	batchOf := map[string]int{}
+	for i, spec := range specs {
+		key := spec.Subnet + "|" + strings.Join(spec.SecurityGroups, ",") + "|" + spec.UserData
+		b, ok := batchOf[key]
+		if !ok {
+			b = len(batches)
+			batchOf[key] = b
+			batches = append(batches, nil)
+		}
+		batches[b] = append(batches[b], i)
 	}
-	res, err := api.RunInstances(req)
-	if err != nil {
-		return "", err
+
+	thisHost, _ := os.Hostname()
+	tags := []*ec2.Tag{
+		{Key: aws.String("ProvisionedBy"), Value: aws.String("Kismatic")},
+		{Key: aws.String("CreatedBy"), Value: aws.String(thisHost)},
+		{Key: aws.String("KismaticRole"), Value: aws.String(role)},
 	}
-	instanceID := res.Instances[0].InstanceId
-	// Modify the node
-	modifyReq := &ec2.ModifyInstanceAttributeInput{
-		InstanceId: instanceID,
-		SourceDestCheck: &ec2.AttributeBooleanValue{
-			Value: aws.Bool(false),
-		},
+	if c.Config.ClusterTag != "" {
+		tags = append(tags, &ec2.Tag{Key: aws.String(c.Config.ClusterTag), Value: aws.String("owned")})
 	}
-	_, err = api.ModifyInstanceAttribute(modifyReq)
-	if err != nil {
-		if err = c.DestroyNodes([]string{*instanceID}); err != nil {
-			fmt.Printf("AWS NODE %q MUST BE CLEANED UP MANUALLY\n", instanceID)
+
+	ids := make([]string, len(specs))
+	// created are the machines launched so far, which are destroyed if a request fails
+	created := []string{}
+	destroy := func() {
+		if len(created) == 0 {
+			return
 		}
-		return "", err
-	}
-	if err := c.tagResourceProvisionedBy(instanceID); err != nil {
-		if err = c.DestroyNodes([]string{*instanceID}); err != nil {
-			fmt.Printf("AWS NODE %q MUST BE CLEANED UP MANUALLY\n", *instanceID)
+		if err := c.DestroyNodes(created); err != nil {
+			fmt.Printf("AWS NODES %v MUST BE CLEANED UP MANUALLY\n", created)
 		}
-		return "", err
 	}
+	for _, batch := range batches {
+		spec := specs[batch[0]]
+		count := int64(len(batch))
+		req := &ec2.RunInstancesInput{
+			ImageId: aws.String(string(ami)),
+			BlockDeviceMappings: append([]*ec2.BlockDeviceMapping{
+				{
+					DeviceName: aws.String("/dev/sda1"),
+					Ebs: &ec2.EbsBlockDevice{
+						DeleteOnTermination: aws.Bool(true),
+						VolumeSize:          aws.Int64(size),
+					},
+				},
+			}, c.Config.blockDeviceMappings(role)...),
+			InstanceType: aws.String(string(instanceType)),
+			MinCount:     aws.Int64(count),
+			MaxCount:     aws.Int64(count),
+			KeyName:      aws.String(c.Config.Keyname),
+			NetworkInterfaces: []*ec2.InstanceNetworkInterfaceSpecification{
+				&ec2.InstanceNetworkInterfaceSpecification{
+					AssociatePublicIpAddress: aws.Bool(c.Config.hasPublicIP(role)),
+					DeviceIndex:              aws.Int64(0),
+					SubnetId:                 aws.String(spec.Subnet),
+					Groups:                   aws.StringSlice(spec.SecurityGroups),
+				},
+			},
+			TagSpecifications: []*ec2.TagSpecification{
+				{ResourceType: aws.String(ec2.ResourceTypeInstance), Tags: tags},
+				{ResourceType: aws.String(ec2.ResourceTypeVolume), Tags: tags},
+			},
+		}
+		c.Config.Hardening.apply(req)
+		if profile, ok := c.Config.InstanceProfiles[role]; ok {
+			req.IamInstanceProfile = &ec2.IamInstanceProfileSpecification{Name: aws.String(profile)}
+		}
+		if spec.UserData != "" {
+			req.UserData = aws.String(base64.StdEncoding.EncodeToString([]byte(spec.UserData)))
+		}
+		res, err := c.runInstances(req, role)
+		if err != nil {
+			destroy()
+			return nil, err
+		}
+		instances := res.Instances
+		sort.Slice(instances, func(i, j int) bool {
+			return aws.Int64Value(instances[i].AmiLaunchIndex) < aws.Int64Value(instances[j].AmiLaunchIndex)
+		})
+		for _, instance := range instances {
+			created = append(created, *instance.InstanceId)
+		}
 
-	return *res.Instances[0].InstanceId, nil
+		// The source/dest check cannot be set when launching, so it is disabled on the
+		// network interface of each instance right away. Calico routes pod traffic
+		// through the nodes, which requires it to be disabled.
+		for _, instance := range instances {
+			_, err := api.ModifyNetworkInterfaceAttribute(&ec2.ModifyNetworkInterfaceAttributeInput{
+				NetworkInterfaceId: instance.NetworkInterfaces[0].NetworkInterfaceId,
+				SourceDestCheck: &ec2.AttributeBooleanValue{
+					Value: aws.Bool(false),
+				},
+			})
+			if err != nil {
+				destroy()
+				return nil, err
+			}
+		}
+		for i, n := range batch {
+			ids[n] = *instances[i].InstanceId
+		}
+	}
+	return ids, nil
 }
 
 func (c Client) tagResourceProvisionedBy(resourceId *string) error {
@@ -178,40 +317,54 @@ func (c Client) TagResourceName(resourceId *string, name string) error {
 	return nil
 }
 
-// GetNode returns information about a specific node. The consumer of this method
-// is responsible for checking that the information it needs has been returned
-// in the Node. (i.e. it's possible for the hostname, public IP to be empty)
-func (c Client) GetNode(id string) (*Node, error) {
+// DescribeNodes returns information about the nodes, keyed by ID, using a single call. The
+// consumer of this method is responsible for checking that the information it needs has been
+// returned in the Node. (i.e. it's possible for the hostname, public IP to be empty)
+func (c Client) DescribeNodes(ids []string) (map[string]Node, error) {
 	api, err := c.getAPIClient()
 	if err != nil {
 		return nil, err
 	}
-	req := &ec2.DescribeInstancesInput{
-		InstanceIds: []*string{aws.String(id)},
-	}
-	resp, err := api.DescribeInstances(req)
+	nodes := map[string]Node{}
+	err = api.DescribeInstancesPages(&ec2.DescribeInstancesInput{InstanceIds: aws.StringSlice(ids)}, func(page *ec2.DescribeInstancesOutput, last bool) bool {
+		for _, reservation := range page.Reservations {
+			for _, instance := range reservation.Instances {
+				nodes[*instance.InstanceId] = nodeFromInstance(instance)
+			}
+		}
+		return true
+	})
 	if err != nil {
 		return nil, err
 	}
-	if len(resp.Reservations) != 1 {
-		return nil, fmt.Errorf("Attempted to get a single node, but API returned %d reservations", len(resp.Reservations))
-	}
-	if len(resp.Reservations[0].Instances) != 1 {
-		return nil, fmt.Errorf("Attempted to get a single node, but API returned %d instances", len(resp.Reservations[0].Instances))
-	}
-	instance := resp.Reservations[0].Instances[0]
+	return nodes, nil
+}
 
-	var publicIP string
-	if instance.PublicIpAddress != nil {
-		publicIP = *instance.PublicIpAddress
-	}
-	return &Node{
-		PrivateDNSName: *instance.PrivateDnsName,
-		PrivateIP:      *instance.PrivateIpAddress,
-		PublicIP:       publicIP,
+func nodeFromInstance(instance *ec2.Instance) Node {
+	n := Node{
+		ID:             *instance.InstanceId,
+		PrivateDNSName: aws.StringValue(instance.PrivateDnsName),
+		PrivateIP:      aws.StringValue(instance.PrivateIpAddress),
+		PublicIP:       aws.StringValue(instance.PublicIpAddress),
 		SSHUser:        defaultSSHUserForAMI(AMI(*instance.ImageId)),
 		ImageID:        *instance.ImageId,
-	}, nil
+		Zone:           aws.StringValue(instance.Placement.AvailabilityZone),
+		State:          aws.StringValue(instance.State.Name),
+	}
+	if instance.StateReason != nil {
+		n.StateReason = aws.StringValue(instance.StateReason.Message)
+	}
+	for _, t := range instance.Tags {
+		switch *t.Key {
+		case "KismaticRole":
+			n.Role = *t.Value
+		case dnsZoneTag:
+			n.DNSZone = *t.Value
+		case dnsNamesTag:
+			n.DNSNames = strings.Split(*t.Value, ",")
+		}
+	}
+	return n
 }
 
 // DestroyNodes destroys the nodes identified by the ID.
@@ -281,31 +434,63 @@ func (c Client) GetNodes() ([]string, error) {
 	return allids, nil
 }
 
-func (c *Client) MaybeProvisionKeypair(keyloc string) error {
+// ListNodes returns the running nodes that were provisioned by this tool from this machine.
+// Nodes are returned in the order in which they were launched.
+func (c Client) ListNodes() ([]Node, error) {
+	thisHost, _ := os.Hostname()
+	filters := []*ec2.Filter{
+		&ec2.Filter{
+			Name:   aws.String("instance-state-name"),
+			Values: []*string{aws.String("running")},
+		},
+		&ec2.Filter{
+			Name:   aws.String("tag:ProvisionedBy"),
+			Values: []*string{aws.String("Kismatic")},
+		},
+		&ec2.Filter{
+			Name:   aws.String("tag:CreatedBy"),
+			Values: []*string{aws.String(thisHost)},
+		},
+	}
 	client, err := c.getAPIClient()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	instances := []*ec2.Instance{}
+	err = client.DescribeInstancesPages(&ec2.DescribeInstancesInput{Filters: filters}, func(page *ec2.DescribeInstancesOutput, last bool) bool {
+		for _, reservation := range page.Reservations {
+			instances = append(instances, reservation.Instances...)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].LaunchTime.Before(*instances[j].LaunchTime)
+	})
+
+	nodes := []Node{}
+	for _, instance := range instances {
+		nodes = append(nodes, nodeFromInstance(instance))
 	}
+	return nodes, nil
+}
 
-	//look for an existing keypair
-	q := &ec2.DescribeKeyPairsInput{
-		KeyNames: []*string{aws.String(c.Config.Keyname)},
+func (c *Client) MaybeProvisionKeypair(keyloc string) error {
+	client, err := c.getAPIClient()
+	if err != nil {
+		return err
 	}
-	a, err := client.DescribeKeyPairs(q)
 
-	switch err := err.(type) {
-	case nil:
-		if len(a.KeyPairs) > 0 {
-			fmt.Printf("Found keypair %v\n", a.KeyPairs[0].KeyFingerprint)
-		}
-		return nil
-	case awserr.Error:
-		if err.Code() != "InvalidKeyPair.NotFound" {
-			return err
-		}
-	default:
+	//look for an existing keypair, which is of no use without its private key
+	kp, err := c.describeKeypair()
+	if err != nil {
 		return err
 	}
+	if kp != nil {
+		return fmt.Errorf("keypair %q exists, but its private key %v was not found. Set AWS_SSH_KEY_PATH to the private key, or AWS_KEY_NAME to create another keypair", c.Config.Keyname, keyloc)
+	}
 
 	//if it isn't there, try to make it
 	fmt.Printf("Creating new keypair %v\n", c.Config.Keyname)
@@ -333,7 +518,9 @@ func (c *Client) MaybeProvisionKeypair(keyloc string) error {
 	return err
 }
 
-func (c *Client) MaybeProvisionVPC() (string, error) {
+// MaybeProvisionVPC returns the VPC tagged as provisioned by Kismatic, or creates it
+// with the given CIDR.
+func (c *Client) MaybeProvisionVPC(cidr string) (string, error) {
 	client, err := c.getAPIClient()
 	if err != nil {
 		return "", err
@@ -354,12 +541,15 @@ func (c *Client) MaybeProvisionVPC() (string, error) {
 	}
 	if len(a.Vpcs) > 0 {
 		fmt.Println("Found tagged VPC")
+		if found := aws.StringValue(a.Vpcs[0].CidrBlock); found != cidr {
+			return "", fmt.Errorf("the tagged VPC %s has CIDR %s, which does not match --vpc-cidr %s", *a.Vpcs[0].VpcId, found, cidr)
+		}
 		return *a.Vpcs[0].VpcId, nil
 	}
 
 	//make a new VPC
 	q2 := &ec2.CreateVpcInput{
-		CidrBlock: aws.String("10.0.0.0/16"),
+		CidrBlock: aws.String(cidr),
 	}
 
 	fmt.Println("Creating new VPC")
@@ -377,7 +567,9 @@ func (c *Client) MaybeProvisionVPC() (string, error) {
 	return *a2.Vpc.VpcId, nil
 }
 
-func (c *Client) MaybeProvisionRoute(vpc, igw, subnet string) (string, error) {
+// MaybeProvisionRoute routes the traffic of the VPC to the internet gateway, and
+// associates the subnets with the route table.
+func (c *Client) MaybeProvisionRoute(vpc, igw string, subnets []string) (string, error) {
 	client, err := c.getAPIClient()
 	if err != nil {
 		return "", err
@@ -396,82 +588,60 @@ func (c *Client) MaybeProvisionRoute(vpc, igw, subnet string) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	table := a.RouteTables[0]
 
-	fmt.Printf("Found Route Table %v\n", *a.RouteTables[0].RouteTableId)
+	fmt.Printf("Found Route Table %v\n", *table.RouteTableId)
 
-	for _, r := range a.RouteTables[0].Routes {
-		if *r.GatewayId == igw {
-			return *a.RouteTables[0].RouteTableId, nil //exit if already provisioned
+	routed := false
+	for _, r := range table.Routes {
+		if aws.StringValue(r.GatewayId) == igw {
+			routed = true
 		}
 	}
 
-	fmt.Printf("Creating route from Internet Gateway %v to Route %v\n", igw, *a.RouteTables[0].RouteTableId)
-	q3 := &ec2.CreateRouteInput{
-		DestinationCidrBlock: aws.String("0.0.0.0/0"),
-		GatewayId:            aws.String(igw),
-		RouteTableId:         a.RouteTables[0].RouteTableId,
-	}
-
-	if _, err := client.CreateRoute(q3); err != nil {
-		return "", err
-	}
+	if !routed {
+		fmt.Printf("Creating route from Internet Gateway %v to Route %v\n", igw, *table.RouteTableId)
+		q3 := &ec2.CreateRouteInput{
+			DestinationCidrBlock: aws.String("0.0.0.0/0"),
+			GatewayId:            aws.String(igw),
+			RouteTableId:         table.RouteTableId,
+		}
 
-	q4 := &ec2.AssociateRouteTableInput{
-		RouteTableId: a.RouteTables[0].RouteTableId,
-		SubnetId:     aws.String(subnet),
-	}
+		if _, err := client.CreateRoute(q3); err != nil {
+			return "", err
+		}
 
-	fmt.Printf("Associating Subnet %v with Route %v\n", *q4.SubnetId, *q4.RouteTableId)
-	if _, err := client.AssociateRouteTable(q4); err != nil {
-		return "", err
-	}
+		if err := c.tagResourceProvisionedBy(table.RouteTableId); err != nil {
+			fmt.Println("Error tagging new Route Table")
+		}
 
-	if err := c.tagResourceProvisionedBy(a.RouteTables[0].RouteTableId); err != nil {
-		fmt.Println("Error tagging new Route Table")
+		c.TagResourceName(table.RouteTableId, "Kismatic Route Table")
 	}
 
-	c.TagResourceName(a.RouteTables[0].RouteTableId, "Kismatic Route Table")
-
-	return *a.RouteTables[0].RouteTableId, nil
-}
-
-func (c *Client) MaybeProvisionSubnet(vpc string) (string, error) {
-	client, err := c.getAPIClient()
-	if err != nil {
-		return "", err
-	}
-	q := &ec2.DescribeSubnetsInput{
-		Filters: []*ec2.Filter{
-			&ec2.Filter{
-				Name:   aws.String("vpc-id"),
-				Values: []*string{aws.String(vpc)},
-			},
-		},
-	}
-	a, err := client.DescribeSubnets(q)
-	if err != nil {
-		return "", err
-	}
-	if len(a.Subnets) > 0 {
-		return *a.Subnets[0].SubnetId, nil
-	}
-
-	q2 := &ec2.CreateSubnetInput{
-		CidrBlock: aws.String("10.0.0.0/24"),
-		VpcId:     aws.String(vpc),
-	}
-	fmt.Println("Creating new Subnet")
-	a2, err := client.CreateSubnet(q2)
-	if err != nil {
-		return "", err
+	associated := map[string]bool{}
+	for _, as := range table.Associations {
+		associated[aws.StringValue(as.SubnetId)] = true
 	}
+	for _, sn := range subnets {
+		if associated[sn] {
+			continue
+		}
+		q4 := &ec2.AssociateRouteTableInput{
+			RouteTableId: table.RouteTableId,
+			SubnetId:     aws.String(sn),
+		}
 
-	if err := c.tagResourceProvisionedBy(a2.Subnet.SubnetId); err != nil {
-		fmt.Println("Error tagging new Subnet")
+		fmt.Printf("Associating Subnet %v with Route %v\n", *q4.SubnetId, *q4.RouteTableId)
+		_, err := client.AssociateRouteTable(q4)
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "Resource.AlreadyAssociated" {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
 	}
-	c.TagResourceName(a2.Subnet.SubnetId, "Kismatic Subnet")
 
-	return *a2.Subnet.SubnetId, nil
+	return *table.RouteTableId, nil
 }
 
 func (c *Client) MaybeProvisionIG(vpc string) (string, error) {
@@ -0,0 +1,102 @@
+package aws
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// masterCloudProviderPolicy lets the AWS cloud provider of the masters manage the load
+// balancers, volumes and routes of the cluster.
+const masterCloudProviderPolicy = `{
+  "Version": "2012-10-17",
+  "Statement": [
+    {
+      "Effect": "Allow",
+      "Action": [
+        "ec2:AttachVolume",
+        "ec2:AuthorizeSecurityGroupIngress",
+        "ec2:CreateRoute",
+        "ec2:CreateSecurityGroup",
+        "ec2:CreateTags",
+        "ec2:CreateVolume",
+        "ec2:DeleteRoute",
+        "ec2:DeleteSecurityGroup",
+        "ec2:DeleteVolume",
+        "ec2:DescribeInstances",
+        "ec2:DescribeRegions",
+        "ec2:DescribeRouteTables",
+        "ec2:DescribeSecurityGroups",
+        "ec2:DescribeSubnets",
+        "ec2:DescribeVolumes",
+        "ec2:DescribeVpcs",
+        "ec2:DetachVolume",
+        "ec2:ModifyInstanceAttribute",
+        "ec2:ModifyVolume",
+        "ec2:RevokeSecurityGroupIngress",
+        "elasticloadbalancing:*",
+        "iam:CreateServiceLinkedRole",
+        "kms:DescribeKey"
+      ],
+      "Resource": "*"
+    }
+  ]
+}`
+
+// nodeCloudProviderPolicy lets the kubelet of the workers look up their instance, and
+// pull images from ECR.
+const nodeCloudProviderPolicy = `{
+  "Version": "2012-10-17",
+  "Statement": [
+    {
+      "Effect": "Allow",
+      "Action": [
+        "ec2:DescribeInstances",
+        "ec2:DescribeRegions",
+        "ecr:BatchCheckLayerAvailability",
+        "ecr:BatchGetImage",
+        "ecr:DescribeRepositories",
+        "ecr:GetAuthorizationToken",
+        "ecr:GetDownloadUrlForLayer",
+        "ecr:GetRepositoryPolicy",
+        "ecr:ListImages"
+      ],
+      "Resource": "*"
+    }
+  ]
+}`
+
+// clusterTagKey returns the tag the AWS cloud provider uses to find the resources of the cluster
+func clusterTagKey(clusterName string) string {
+	return "kubernetes.io/cluster/" + clusterName
+}
+
+// TagClusterResources tags the subnets and the cluster security group of the nodes with the
+// cluster tag, so that the AWS cloud provider places the load balancers of the services in
+// them. They are tagged as shared, as other clusters may use them too.
+func (c *Client) TagClusterResources(clusterName string) error {
+	api, err := c.getAPIClient()
+	if err != nil {
+		return err
+	}
+	resources := aws.StringSlice(c.Config.SubnetIDs)
+	// The cloud provider expects a single tagged security group per instance
+	if c.Config.SecurityGroupID != "" {
+		resources = append(resources, aws.String(c.Config.SecurityGroupID))
+	}
+	fmt.Printf("Tagging %v for the AWS cloud provider\n", aws.StringValueSlice(resources))
+	_, err = api.CreateTags(&ec2.CreateTagsInput{
+		Resources: resources,
+		Tags: []*ec2.Tag{
+			{Key: aws.String(clusterTagKey(clusterName)), Value: aws.String("shared")},
+		},
+	})
+	return err
+}
+
+// SetUpCloudProvider tags the instances and the resources of the cluster for the AWS cloud provider
+func (c *Client) SetUpCloudProvider(clusterName string) error {
+	c.Config.ClusterTag = clusterTagKey(clusterName)
+	return c.TagClusterResources(clusterName)
+}
@@ -0,0 +1,230 @@
+package aws
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/apprenda/kismatic-provision/provision/utils"
+	"github.com/spf13/cobra"
+)
+
+// hoursPerMonth is the number of hours AWS bills in a month
+const hoursPerMonth = 730
+
+// regionPrices are the on-demand Linux prices of a region, in USD
+type regionPrices struct {
+	// instances are per hour
+	instances map[InstanceType]float64
+	// volumes are per GB-month, and iops per provisioned IOPS-month
+	volumes map[string]float64
+	iops    float64
+	// loadBalancer is per hour, before capacity units
+	loadBalancer float64
+}
+
+// prices is the bundled price table, used to estimate the cost of a cluster before it is created.
+// It only covers the instance types of the blueprints, and may lag behind the AWS price list.
+var prices = map[string]regionPrices{
+	"us-east-1": {
+		instances:    map[InstanceType]float64{"t2.micro": 0.0116, "t2.medium": 0.0464, "m4.large": 0.10, "m4.xlarge": 0.20},
+		volumes:      map[string]float64{"gp2": 0.10, "gp3": 0.08, "io1": 0.125, "st1": 0.045, "sc1": 0.025, "standard": 0.05},
+		iops:         0.065,
+		loadBalancer: 0.0225,
+	},
+	"us-east-2": {
+		instances:    map[InstanceType]float64{"t2.micro": 0.0116, "t2.medium": 0.0464, "m4.large": 0.10, "m4.xlarge": 0.20},
+		volumes:      map[string]float64{"gp2": 0.10, "gp3": 0.08, "io1": 0.125, "st1": 0.045, "sc1": 0.025, "standard": 0.05},
+		iops:         0.065,
+		loadBalancer: 0.0225,
+	},
+	"us-west-1": {
+		instances:    map[InstanceType]float64{"t2.micro": 0.0138, "t2.medium": 0.0552, "m4.large": 0.117, "m4.xlarge": 0.234},
+		volumes:      map[string]float64{"gp2": 0.12, "gp3": 0.096, "io1": 0.138, "st1": 0.054, "sc1": 0.03, "standard": 0.08},
+		iops:         0.072,
+		loadBalancer: 0.0252,
+	},
+	"us-west-2": {
+		instances:    map[InstanceType]float64{"t2.micro": 0.0116, "t2.medium": 0.0464, "m4.large": 0.10, "m4.xlarge": 0.20},
+		volumes:      map[string]float64{"gp2": 0.10, "gp3": 0.08, "io1": 0.125, "st1": 0.045, "sc1": 0.025, "standard": 0.05},
+		iops:         0.065,
+		loadBalancer: 0.0225,
+	},
+	"eu-west-1": {
+		instances:    map[InstanceType]float64{"t2.micro": 0.0126, "t2.medium": 0.05, "m4.large": 0.111, "m4.xlarge": 0.222},
+		volumes:      map[string]float64{"gp2": 0.11, "gp3": 0.088, "io1": 0.138, "st1": 0.05, "sc1": 0.028, "standard": 0.055},
+		iops:         0.072,
+		loadBalancer: 0.0252,
+	},
+}
+
+// CostOpts control the review of the cost of the cluster before it is created
+type CostOpts struct {
+	DryRun        bool
+	MaxHourlyCost float64
+}
+
+func addCostFlags(cmd *cobra.Command, opts *CostOpts) {
+	cmd.Flags().BoolVar(&opts.DryRun, "dry-run", false, "Print what would be created, with an estimate of its cost, without creating anything.")
+	cmd.Flags().Float64Var(&opts.MaxHourlyCost, "max-hourly-cost", 0, "Ask for confirmation before creating a cluster whose estimated cost is above this hourly amount in USD.")
+}
+
+// Validate checks the cost threshold
+func (opts CostOpts) Validate() error {
+	if opts.MaxHourlyCost < 0 {
+		return errors.New("--max-hourly-cost cannot be negative")
+	}
+	return nil
+}
+
+// roleEstimate is the cost of the nodes of a role
+type roleEstimate struct {
+	role         string
+	count        uint16
+	instanceType InstanceType
+	disk         int64
+	volumes      []VolumeSpec
+	hourly       float64
+}
+
+// costEstimate is the cost of a cluster. Unknown lists what could not be priced.
+type costEstimate struct {
+	region  string
+	roles   []roleEstimate
+	lb      float64
+	hourly  float64
+	unknown []string
+}
+
+// estimateCost estimates the hourly cost of the nodes, their volumes and the load balancer. Spot
+// instances are priced as on-demand instances, which is the most they can cost by default.
+func estimateCost(region string, blueprint NodeBlueprint, count NodeCount, volumes []VolumeSpec, masterLB bool) costEstimate {
+	e := costEstimate{region: region}
+	p, ok := prices[region]
+	if !ok {
+		e.unknown = append(e.unknown, "region "+region)
+	}
+	roles := []roleEstimate{
+		{role: "etcd", count: count.Etcd, instanceType: blueprint.EtcdInstanceType, disk: blueprint.EtcdDisk},
+		{role: "master", count: count.Master, instanceType: blueprint.MasterInstanceType, disk: blueprint.MasterDisk},
+		{role: "worker", count: count.Worker, instanceType: blueprint.WorkerInstanceType, disk: blueprint.WorkerDisk},
+		{role: "bootstrap", count: count.Bootstrap, instanceType: blueprint.EtcdInstanceType, disk: blueprint.EtcdDisk},
+	}
+	for _, r := range roles {
+		if r.count == 0 {
+			continue
+		}
+		for _, v := range volumes {
+			if v.Role == r.role {
+				r.volumes = append(r.volumes, v)
+			}
+		}
+		if ok {
+			price, known := p.instances[r.instanceType]
+			if unknown := "instance type " + string(r.instanceType); !known && !contains(e.unknown, unknown) {
+				e.unknown = append(e.unknown, unknown)
+			}
+			// The root volume is gp2
+			monthly := float64(r.disk) * p.volumes["gp2"]
+			for _, v := range r.volumes {
+				monthly += float64(v.SizeGB)*p.volumes[v.Type] + float64(v.IOPS)*p.iops
+			}
+			r.hourly = float64(r.count) * (price + monthly/hoursPerMonth)
+		}
+		e.hourly += r.hourly
+		e.roles = append(e.roles, r)
+	}
+	if masterLB && ok {
+		e.lb = p.loadBalancer
+		e.hourly += e.lb
+	}
+	return e
+}
+
+func (e costEstimate) print() {
+	fmt.Printf("Instances in %v:\n", e.region)
+	for _, r := range e.roles {
+		fmt.Printf("  %-9v %3d x %-10v %13v  %d GB root volume", r.role, r.count, r.instanceType, fmt.Sprintf("$%.4f/hour", r.hourly), r.disk)
+		for _, v := range r.volumes {
+			fmt.Printf(", %d GB %s", v.SizeGB, v.Type)
+		}
+		fmt.Println()
+	}
+	if e.lb > 0 {
+		fmt.Printf("  %-26v %13v  network load balancer\n", "master-lb", fmt.Sprintf("$%.4f/hour", e.lb))
+	}
+	fmt.Printf("Estimated cost: $%.2f/hour, $%.2f/month\n", e.hourly, e.hourly*hoursPerMonth)
+	if len(e.unknown) > 0 {
+		fmt.Printf("The estimate leaves out the unknown prices of %v\n", strings.Join(e.unknown, ", "))
+	}
+}
+
+// printNetwork prints the network resources that would be created or used
+func printNetwork(opts AWSOpts, subnets []string) {
+	fmt.Println("Network:")
+	switch {
+	case len(subnets) > 0:
+		fmt.Printf("  Subnets %v\n", strings.Join(subnets, ", "))
+	case opts.ForceProvision:
+		fmt.Printf("  VPC %v, unless it exists, with an internet gateway and a route table\n", opts.Network.VPCCIDR)
+		if len(opts.Network.SubnetCIDRs) > 0 {
+			fmt.Printf("  Subnets %v\n", strings.Join(opts.Network.SubnetCIDRs, ", "))
+		} else {
+			fmt.Printf("  A subnet in each of the first %d availability zones\n", maxSubnetZones)
+		}
+	}
+	if opts.ForceProvision {
+		if opts.SecurityGroups.LegacyOpen {
+			fmt.Println("  The default security group of the VPC, opened to all traffic")
+		} else {
+			fmt.Printf("  Security groups for the cluster and the %v roles\n", strings.Join(securityGroupRoles, ", "))
+		}
+	}
+	if opts.MasterLB {
+		fmt.Println("  A network load balancer in front of the masters")
+	}
+	if opts.HostedZone != "" {
+		fmt.Printf("  DNS records in %v\n", opts.HostedZone)
+	}
+}
+
+// reviewCost prints what would be created with its estimated cost on a dry run, and asks for
+// confirmation when the estimate is above the maximum hourly cost, or leaves out unknown prices
+// and so cannot be compared with it. Returns whether to stop.
+func reviewCost(opts AWSOpts, awsClient *awsProvisioner, blueprint NodeBlueprint, count NodeCount, volumes []VolumeSpec) (bool, error) {
+	if !opts.Cost.DryRun && opts.Cost.MaxHourlyCost == 0 {
+		return false, nil
+	}
+	subnets := awsClient.client.Config.SubnetIDs
+	if len(opts.Subnets) > 0 {
+		subnets = opts.Subnets
+	}
+	e := estimateCost(awsClient.client.Config.Region, blueprint, count, volumes, opts.MasterLB)
+	if opts.Cost.DryRun {
+		e.print()
+		printNetwork(opts, subnets)
+		fmt.Println("Nothing was created, as this is a dry run.")
+		return true, nil
+	}
+	if e.hourly <= opts.Cost.MaxHourlyCost && len(e.unknown) == 0 {
+		return false, nil
+	}
+	e.print()
+	prompt := fmt.Sprintf("The estimated cost is above the maximum of $%.2f/hour. Create the cluster?", opts.Cost.MaxHourlyCost)
+	if e.hourly <= opts.Cost.MaxHourlyCost {
+		prompt = fmt.Sprintf("The estimate leaves out unknown prices, so the cost may be above the maximum of $%.2f/hour. Create the cluster?", opts.Cost.MaxHourlyCost)
+	}
+	if !utils.AskForConfirmation(prompt) {
+		return true, errors.New("the cluster was not created")
+	}
+	return false, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package aws
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestEstimateCost(t *testing.T) {
+	small := NodeBlueprint{EtcdInstanceType: "t2.medium", MasterInstanceType: "t2.medium", WorkerInstanceType: "m4.large", EtcdDisk: 10, MasterDisk: 10, WorkerDisk: 10}
+	unknownWorker := small
+	unknownWorker.WorkerInstanceType = "c5.large"
+	tests := []struct {
+		region    string
+		blueprint NodeBlueprint
+		count     NodeCount
+		volumes   []VolumeSpec
+		masterLB  bool
+		hourly    float64
+		unknown   []string
+	}{
+		{
+			region:    "us-east-1",
+			blueprint: small,
+			count:     NodeCount{Etcd: 1, Master: 1, Worker: 1},
+			hourly:    0.0464*2 + 0.10 + 3*10*0.10/hoursPerMonth,
+		},
+		{
+			region:    "us-west-1",
+			blueprint: small,
+			count:     NodeCount{Master: 2, Worker: 1},
+			volumes:   []VolumeSpec{{Role: "worker", SizeGB: 100, Type: "io1", IOPS: 1000}, {Role: "etcd", SizeGB: 50, Type: "gp2"}},
+			masterLB:  true,
+			hourly:    2*(0.0552+10*0.12/hoursPerMonth) + 0.117 + (10*0.12+100*0.138+1000*0.072)/hoursPerMonth + 0.0252,
+		},
+		{
+			region:    "ap-south-1",
+			blueprint: small,
+			count:     NodeCount{Worker: 1},
+			masterLB:  true,
+			unknown:   []string{"region ap-south-1"},
+		},
+		{
+			// The volumes of the workers are priced even though their instance type is not
+			region:    "us-east-1",
+			blueprint: unknownWorker,
+			count:     NodeCount{Worker: 2, Bootstrap: 1},
+			hourly:    0.0464 + 10*0.10/hoursPerMonth + 2*10*0.10/hoursPerMonth,
+			unknown:   []string{"instance type c5.large"},
+		},
+	}
+	for i, test := range tests {
+		e := estimateCost(test.region, test.blueprint, test.count, test.volumes, test.masterLB)
+		if math.Abs(e.hourly-test.hourly) > 1e-9 {
+			t.Errorf("test %d: expected $%f/hour, got $%f/hour", i, test.hourly, e.hourly)
+		}
+		if !reflect.DeepEqual(e.unknown, test.unknown) {
+			t.Errorf("test %d: expected unknown prices %v, got %v", i, test.unknown, e.unknown)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package aws
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/apprenda/kismatic-provision/provision/plan"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+const (
+	recordTTL = 60
+
+	// The hosted zone and the names of the records of a node are kept in tags of the
+	// instance, so that delete-all can find the records.
+	dnsZoneTag  = "KismaticDNSZone"
+	dnsNamesTag = "KismaticDNSNames"
+)
+
+var invalidDNSLabelChars = regexp.MustCompile("[^a-z0-9-]+")
+
+// hostedZone is a Route53 hosted zone
+type hostedZone struct {
+	id   string
+	name string
+}
+
+// recordName returns the name of a record of the cluster in the zone
+func (z hostedZone) recordName(clusterName, label string) string {
+	cluster := strings.Trim(invalidDNSLabelChars.ReplaceAllString(strings.ToLower(clusterName), "-"), "-")
+	return fmt.Sprintf("%s.%s.%s", label, cluster, z.name)
+}
+
+// nodeRecordName returns the name of the record of the i-th node of a role, e.g. master0
+func (z hostedZone) nodeRecordName(clusterName, role string, i int) string {
+	return z.recordName(clusterName, fmt.Sprintf("%s%d", role, i))
+}
+
+// apiRecordName returns the name of the record of the API server
+func (z hostedZone) apiRecordName(clusterName string) string {
+	return z.recordName(clusterName, "api")
+}
+
+func (c *Client) getDNSClient() (*route53.Route53, error) {
+	if c.dnsClient == nil {
+		sess, err := c.getSession()
+		if err != nil {
+			return nil, err
+		}
+		c.dnsClient = route53.New(sess)
+	}
+	return c.dnsClient, nil
+}
+
+// LookupHostedZone returns the hosted zone with the given domain name
+func (c *Client) LookupHostedZone(name string) (*hostedZone, error) {
+	api, err := c.getDNSClient()
+	if err != nil {
+		return nil, err
+	}
+	name = strings.TrimSuffix(name, ".")
+	res, err := api.ListHostedZonesByName(&route53.ListHostedZonesByNameInput{DNSName: aws.String(name)})
+	if err != nil {
+		return nil, err
+	}
+	for _, z := range res.HostedZones {
+		if strings.TrimSuffix(*z.Name, ".") == name {
+			return &hostedZone{id: *z.Id, name: name}, nil
+		}
+	}
+	return nil, fmt.Errorf("hosted zone %q was not found", name)
+}
+
+// upsertRecord creates or replaces the record
+func (c *Client) upsertRecord(zone hostedZone, name, recordType string, values []string) error {
+	api, err := c.getDNSClient()
+	if err != nil {
+		return err
+	}
+	records := []*route53.ResourceRecord{}
+	for _, v := range values {
+		records = append(records, &route53.ResourceRecord{Value: aws.String(v)})
+	}
+	fmt.Printf("Creating DNS record %v %v %v\n", name, recordType, values)
+	_, err = api.ChangeResourceRecordSets(&route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(zone.id),
+		ChangeBatch: &route53.ChangeBatch{
+			Changes: []*route53.Change{
+				{
+					Action: aws.String(route53.ChangeActionUpsert),
+					ResourceRecordSet: &route53.ResourceRecordSet{
+						Name:            aws.String(name),
+						Type:            aws.String(recordType),
+						TTL:             aws.Int64(recordTTL),
+						ResourceRecords: records,
+					},
+				},
+			},
+		},
+	})
+	return err
+}
+
+// tagRecords records the names of the records of the node on its instance
+func (c *Client) tagRecords(zone hostedZone, node plan.Node, names []string) error {
+	api, err := c.getAPIClient()
+	if err != nil {
+		return err
+	}
+	_, err = api.CreateTags(&ec2.CreateTagsInput{
+		Resources: []*string{aws.String(node.ID)},
+		Tags: []*ec2.Tag{
+			{Key: aws.String(dnsZoneTag), Value: aws.String(zone.id)},
+			{Key: aws.String(dnsNamesTag), Value: aws.String(strings.Join(names, ","))},
+		},
+	})
+	return err
+}
+
+// CreateNodeRecords creates an A record for each node, named after its role and index,
+// pointing at the IP it is reached by. Returns the names of the records, keyed by node ID.
+func (c *Client) CreateNodeRecords(zone hostedZone, clusterName string, nodes ProvisionedNodes) (map[string]string, error) {
+	names := map[string]string{}
+	roles := []struct {
+		name  string
+		nodes []plan.Node
+	}{
+		{"etcd", nodes.Etcd},
+		{"master", nodes.Master},
+		{"worker", nodes.Worker},
+		{"bootstrap", nodes.Bootstrap},
+	}
+	for _, r := range roles {
+		for i, n := range r.nodes {
+			name := zone.nodeRecordName(clusterName, r.name, i)
+			if err := c.upsertRecord(zone, name, route53.RRTypeA, []string{n.PublicIPv4}); err != nil {
+				return nil, err
+			}
+			if err := c.tagRecords(zone, n, []string{name}); err != nil {
+				return nil, err
+			}
+			names[n.ID] = name
+		}
+	}
+	return names, nil
+}
+
+// CreateAPIRecord creates the record of the API server, which is a CNAME of the load balancer
+// if there is one, or an A record of all the masters otherwise. Returns the name of the record.
+func (c *Client) CreateAPIRecord(zone hostedZone, clusterName string, masters []plan.Node, loadBalancer string) (string, error) {
+	name := zone.apiRecordName(clusterName)
+	var err error
+	if loadBalancer != "" {
+		err = c.upsertRecord(zone, name, route53.RRTypeCname, []string{loadBalancer})
+	} else {
+		ips := []string{}
+		for _, m := range masters {
+			ips = append(ips, m.PublicIPv4)
+		}
+		err = c.upsertRecord(zone, name, route53.RRTypeA, ips)
+	}
+	if err != nil {
+		return "", err
+	}
+	for i, m := range masters {
+		if err := c.tagRecords(zone, m, []string{zone.nodeRecordName(clusterName, "master", i), name}); err != nil {
+			return "", err
+		}
+	}
+	return name, nil
+}
+
+// DeleteRecords deletes the records of the nodes that were created by this tool from this machine
+func (c *Client) DeleteRecords() error {
+	nodes, err := c.ListNodes()
+	if err != nil {
+		return err
+	}
+	byZone := map[string][]string{}
+	for _, n := range nodes {
+		if n.DNSZone != "" {
+			byZone[n.DNSZone] = append(byZone[n.DNSZone], n.DNSNames...)
+		}
+	}
+	if len(byZone) == 0 {
+		return nil
+	}
+
+	api, err := c.getDNSClient()
+	if err != nil {
+		return err
+	}
+	for zone, names := range byZone {
+		deleted := map[string]bool{}
+		for _, name := range names {
+			if deleted[name] {
+				continue
+			}
+			deleted[name] = true
+			// The record has to be deleted with its current values
+			res, err := api.ListResourceRecordSets(&route53.ListResourceRecordSetsInput{
+				HostedZoneId:    aws.String(zone),
+				StartRecordName: aws.String(name),
+				MaxItems:        aws.String("2"),
+			})
+			if err != nil {
+				return err
+			}
+			for _, rs := range res.ResourceRecordSets {
+				t := aws.StringValue(rs.Type)
+				if !strings.EqualFold(strings.TrimSuffix(*rs.Name, "."), name) || (t != route53.RRTypeA && t != route53.RRTypeCname) {
+					continue
+				}
+				fmt.Printf("Deleting DNS record %v\n", name)
+				_, err := api.ChangeResourceRecordSets(&route53.ChangeResourceRecordSetsInput{
+					HostedZoneId: aws.String(zone),
+					ChangeBatch: &route53.ChangeBatch{
+						Changes: []*route53.Change{
+							{Action: aws.String(route53.ChangeActionDelete), ResourceRecordSet: rs},
+						},
+					},
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
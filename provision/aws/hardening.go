@@ -0,0 +1,54 @@
+package aws
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/spf13/cobra"
+)
+
+// HardeningOpts secure the instances launched by the tool
+type HardeningOpts struct {
+	EncryptVolumes bool
+	KMSKeyID       string
+	RequireIMDSv2  bool
+}
+
+func addHardeningFlags(cmd *cobra.Command, opts *HardeningOpts) {
+	cmd.Flags().BoolVar(&opts.EncryptVolumes, "encrypt-volumes", false, "Encrypt the root and extra volumes of the nodes, with the default EBS key of the account unless --kms-key is set.")
+	cmd.Flags().StringVar(&opts.KMSKeyID, "kms-key", "", "ID, ARN or alias of the KMS key with which to encrypt the volumes of the nodes. Implies --encrypt-volumes.")
+	cmd.Flags().BoolVar(&opts.RequireIMDSv2, "require-imdsv2", false, "Require the nodes to use session tokens (IMDSv2) to access the instance metadata service.")
+}
+
+// encrypted returns whether the volumes are encrypted
+func (opts HardeningOpts) encrypted() bool {
+	return opts.EncryptVolumes || opts.KMSKeyID != ""
+}
+
+// encrypt encrypts the volume, with the KMS key unless the volume has its own
+func (opts HardeningOpts) encrypt(ebs *ec2.EbsBlockDevice) {
+	if !opts.encrypted() {
+		return
+	}
+	ebs.Encrypted = aws.Bool(true)
+	if opts.KMSKeyID != "" && ebs.KmsKeyId == nil {
+		ebs.KmsKeyId = aws.String(opts.KMSKeyID)
+	}
+}
+
+// apply encrypts the volumes of the request, and requires IMDSv2
+func (opts HardeningOpts) apply(req *ec2.RunInstancesInput) {
+	for _, m := range req.BlockDeviceMappings {
+		if m.Ebs != nil {
+			opts.encrypt(m.Ebs)
+		}
+	}
+	if opts.RequireIMDSv2 {
+		// The hop limit of 2 lets the pods that are not on the host network reach the service
+		// through the node, as they would with IMDSv1.
+		req.MetadataOptions = &ec2.InstanceMetadataOptionsRequest{
+			HttpEndpoint:            aws.String(ec2.InstanceMetadataEndpointStateEnabled),
+			HttpTokens:              aws.String(ec2.HttpTokensStateRequired),
+			HttpPutResponseHopLimit: aws.Int64(2),
+		}
+	}
+}
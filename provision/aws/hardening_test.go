@@ -0,0 +1,52 @@
+package aws
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestHardeningEncrypt(t *testing.T) {
+	tests := []struct {
+		opts     HardeningOpts
+		ebs      ec2.EbsBlockDevice
+		expected ec2.EbsBlockDevice
+	}{
+		{
+			opts:     HardeningOpts{},
+			ebs:      ec2.EbsBlockDevice{},
+			expected: ec2.EbsBlockDevice{},
+		},
+		{
+			opts:     HardeningOpts{EncryptVolumes: true},
+			ebs:      ec2.EbsBlockDevice{},
+			expected: ec2.EbsBlockDevice{Encrypted: aws.Bool(true)},
+		},
+		{
+			opts:     HardeningOpts{KMSKeyID: "alias/kismatic"},
+			ebs:      ec2.EbsBlockDevice{},
+			expected: ec2.EbsBlockDevice{Encrypted: aws.Bool(true), KmsKeyId: aws.String("alias/kismatic")},
+		},
+		// A volume encrypted with the default key of the account gets the KMS key too
+		{
+			opts:     HardeningOpts{KMSKeyID: "alias/kismatic"},
+			ebs:      ec2.EbsBlockDevice{Encrypted: aws.Bool(true)},
+			expected: ec2.EbsBlockDevice{Encrypted: aws.Bool(true), KmsKeyId: aws.String("alias/kismatic")},
+		},
+		// The key of the volume takes precedence
+		{
+			opts:     HardeningOpts{KMSKeyID: "alias/kismatic"},
+			ebs:      ec2.EbsBlockDevice{Encrypted: aws.Bool(true), KmsKeyId: aws.String("alias/data")},
+			expected: ec2.EbsBlockDevice{Encrypted: aws.Bool(true), KmsKeyId: aws.String("alias/data")},
+		},
+	}
+	for i, test := range tests {
+		ebs := test.ebs
+		test.opts.encrypt(&ebs)
+		if !reflect.DeepEqual(ebs, test.expected) {
+			t.Errorf("test %d: expected %v, got %v", i, test.expected, ebs)
+		}
+	}
+}
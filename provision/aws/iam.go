@@ -0,0 +1,240 @@
+package aws
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/spf13/cobra"
+)
+
+// ec2TrustPolicy lets EC2 instances assume the role of their instance profile
+const ec2TrustPolicy = `{
+  "Version": "2012-10-17",
+  "Statement": [
+    {
+      "Effect": "Allow",
+      "Principal": {"Service": "ec2.amazonaws.com"},
+      "Action": "sts:AssumeRole"
+    }
+  ]
+}`
+
+var invalidIAMNameChars = regexp.MustCompile(`[^\w+=,.@-]+`)
+
+// instanceProfileName returns the name of the instance profile, and of its role, for the
+// nodes of a role of the cluster. Role names are limited to 64 characters.
+func instanceProfileName(clusterName, role string) string {
+	name := invalidIAMNameChars.ReplaceAllString(clusterName, "-")
+	if len(name) > 40 {
+		name = name[:40]
+	}
+	return fmt.Sprintf("kismatic-%s-%s", name, role)
+}
+
+func (c *Client) getIAMClient() (*iam.IAM, error) {
+	if c.iamClient == nil {
+		sess, err := c.getSession()
+		if err != nil {
+			return nil, err
+		}
+		c.iamClient = iam.New(sess)
+	}
+	return c.iamClient, nil
+}
+
+// InstanceProfileOpts are the IAM instance profiles of the nodes of each role
+type InstanceProfileOpts struct {
+	Master string
+	Worker string
+}
+
+func addInstanceProfileFlags(cmd *cobra.Command, opts *InstanceProfileOpts) {
+	cmd.Flags().StringVar(&opts.Master, "master-instance-profile", "", "Name of the IAM instance profile of the master nodes. With -f, it is created if it does not exist.")
+	cmd.Flags().StringVar(&opts.Worker, "worker-instance-profile", "", "Name of the IAM instance profile of the worker nodes. With -f, it is created if it does not exist.")
+}
+
+// withDefaults returns the profiles, using the profiles named after the cluster for the
+// roles without one.
+func (opts InstanceProfileOpts) withDefaults(clusterName string) InstanceProfileOpts {
+	if opts.Master == "" {
+		opts.Master = instanceProfileName(clusterName, "master")
+	}
+	if opts.Worker == "" {
+		opts.Worker = instanceProfileName(clusterName, "worker")
+	}
+	return opts
+}
+
+// iamPath returns the path of the IAM roles and instance profiles created from this machine,
+// which is how delete-all finds them.
+func iamPath() string {
+	thisHost, _ := os.Hostname()
+	return "/kismatic/" + invalidIAMNameChars.ReplaceAllString(thisHost, "-") + "/"
+}
+
+// otherHostPath returns whether the IAM path is the one of the roles and instance profiles
+// created from another machine
+func otherHostPath(path *string) bool {
+	p := aws.StringValue(path)
+	return strings.HasPrefix(p, "/kismatic/") && p != iamPath()
+}
+
+// SetUpInstanceProfiles makes sure the instance profiles exist, and launches the nodes of
+// each role with theirs. Missing profiles are created when create is set, with the policy
+// of the AWS cloud provider for their role.
+func (c *Client) SetUpInstanceProfiles(opts InstanceProfileOpts, create bool) error {
+	profiles := []struct{ role, name, policy string }{
+		{"master", opts.Master, masterCloudProviderPolicy},
+		{"worker", opts.Worker, nodeCloudProviderPolicy},
+	}
+	c.Config.InstanceProfiles = map[string]string{}
+	for _, p := range profiles {
+		if p.name == "" {
+			continue
+		}
+		if err := c.MaybeProvisionInstanceProfile(p.name, p.policy, create); err != nil {
+			return err
+		}
+		c.Config.InstanceProfiles[p.role] = p.name
+	}
+	return nil
+}
+
+// MaybeProvisionInstanceProfile makes sure an instance profile with the given name exists.
+// When it does not and create is set, it is created with a role of the same name that EC2
+// instances can assume, which has the policy as its inline policy.
+func (c *Client) MaybeProvisionInstanceProfile(name, policy string, create bool) error {
+	api, err := c.getIAMClient()
+	if err != nil {
+		return err
+	}
+
+	res, err := api.GetInstanceProfile(&iam.GetInstanceProfileInput{InstanceProfileName: aws.String(name)})
+	if err == nil {
+		// It would be deleted by delete-all on the other machine, while the nodes still use it
+		if otherHostPath(res.InstanceProfile.Path) {
+			return fmt.Errorf("IAM instance profile %q was created by provision on another machine (path %s), use another cluster name or instance profile", name, *res.InstanceProfile.Path)
+		}
+		fmt.Printf("Found IAM Instance Profile %v\n", name)
+		return nil
+	}
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != iam.ErrCodeNoSuchEntityException {
+		return err
+	}
+	if !create {
+		return fmt.Errorf("IAM instance profile %q does not exist, use -f to create it", name)
+	}
+
+	role, err := api.GetRole(&iam.GetRoleInput{RoleName: aws.String(name)})
+	if err == nil && otherHostPath(role.Role.Path) {
+		return fmt.Errorf("IAM role %q was created by provision on another machine (path %s), use another cluster name or instance profile", name, *role.Role.Path)
+	}
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == iam.ErrCodeNoSuchEntityException {
+		fmt.Printf("Creating new IAM Role %v\n", name)
+		_, err = api.CreateRole(&iam.CreateRoleInput{
+			RoleName:                 aws.String(name),
+			Path:                     aws.String(iamPath()),
+			AssumeRolePolicyDocument: aws.String(ec2TrustPolicy),
+			Description:              aws.String("Kismatic nodes"),
+		})
+		if err != nil {
+			return err
+		}
+		_, err = api.PutRolePolicy(&iam.PutRolePolicyInput{
+			RoleName:       aws.String(name),
+			PolicyName:     aws.String(name),
+			PolicyDocument: aws.String(policy),
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Creating new IAM Instance Profile %v\n", name)
+	_, err = api.CreateInstanceProfile(&iam.CreateInstanceProfileInput{
+		InstanceProfileName: aws.String(name),
+		Path:                aws.String(iamPath()),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = api.AddRoleToInstanceProfile(&iam.AddRoleToInstanceProfileInput{
+		InstanceProfileName: aws.String(name),
+		RoleName:            aws.String(name),
+	})
+	return err
+}
+
+// DeleteInstanceProfiles deletes the instance profiles and roles that were created by this
+// tool from this machine.
+func (c *Client) DeleteInstanceProfiles() error {
+	api, err := c.getIAMClient()
+	if err != nil {
+		return err
+	}
+	profiles := []*iam.InstanceProfile{}
+	err = api.ListInstanceProfilesPages(&iam.ListInstanceProfilesInput{PathPrefix: aws.String(iamPath())}, func(page *iam.ListInstanceProfilesOutput, last bool) bool {
+		profiles = append(profiles, page.InstanceProfiles...)
+		return true
+	})
+	// Without access to IAM, no instance profile can have been created either
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "AccessDenied" {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, p := range profiles {
+		for _, r := range p.Roles {
+			_, err := api.RemoveRoleFromInstanceProfile(&iam.RemoveRoleFromInstanceProfileInput{
+				InstanceProfileName: p.InstanceProfileName,
+				RoleName:            r.RoleName,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		fmt.Printf("Deleting IAM Instance Profile %v\n", *p.InstanceProfileName)
+		if _, err := api.DeleteInstanceProfile(&iam.DeleteInstanceProfileInput{InstanceProfileName: p.InstanceProfileName}); err != nil {
+			return err
+		}
+	}
+
+	roles := []*iam.Role{}
+	err = api.ListRolesPages(&iam.ListRolesInput{PathPrefix: aws.String(iamPath())}, func(page *iam.ListRolesOutput, last bool) bool {
+		roles = append(roles, page.Roles...)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	for _, r := range roles {
+		// The inline policies of a role have to be deleted first
+		policies, err := api.ListRolePolicies(&iam.ListRolePoliciesInput{RoleName: r.RoleName})
+		if err != nil {
+			return err
+		}
+		for _, p := range policies.PolicyNames {
+			if _, err := api.DeleteRolePolicy(&iam.DeleteRolePolicyInput{RoleName: r.RoleName, PolicyName: p}); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("Deleting IAM Role %v\n", *r.RoleName)
+		if _, err := api.DeleteRole(&iam.DeleteRoleInput{RoleName: r.RoleName}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isInstanceProfileNotReady returns whether the error is due to a new instance profile
+// that EC2 does not know about yet.
+func isInstanceProfileNotReady(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == "InvalidParameterValue" && strings.Contains(aerr.Message(), "iamInstanceProfile")
+}
@@ -0,0 +1,240 @@
+package aws
+
+import (
+	"crypto"
+	"crypto/md5"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/apprenda/kismatic-provision/provision/utils"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+)
+
+// derivePublicKey is the value of --import-public-key when no file is given, in which case
+// the public key is derived from the private key.
+const derivePublicKey = "derive"
+
+func addKeypairFlags(cmd *cobra.Command, publicKey *string) {
+	cmd.Flags().StringVar(publicKey, "import-public-key", "", "Import the public key at this path as the keypair (AWS_KEY_NAME) if it does not exist. Without a path, the public key is derived from the private key (AWS_SSH_KEY_PATH).")
+	cmd.Flags().Lookup("import-public-key").NoOptDefVal = derivePublicKey
+}
+
+// fingerprint formats a digest the way AWS shows key fingerprints
+func fingerprint(digest []byte) string {
+	parts := []string{}
+	for _, b := range digest {
+		parts = append(parts, fmt.Sprintf("%02x", b))
+	}
+	return strings.Join(parts, ":")
+}
+
+// importedFingerprint returns the fingerprint AWS gives to an imported public key, which is
+// the MD5 digest of its DER encoding.
+func importedFingerprint(pub crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	digest := md5.Sum(der)
+	return fingerprint(digest[:]), nil
+}
+
+// createdFingerprint returns the fingerprint AWS gives to a key pair it created, which is
+// the SHA1 digest of the DER encoding of the private key.
+func createdFingerprint(key *rsa.PrivateKey) (string, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+	digest := sha1.Sum(der)
+	return fingerprint(digest[:]), nil
+}
+
+// privateKeyFingerprints returns the fingerprints AWS would give to the private key, whether
+// AWS created it or its public key was imported.
+func privateKeyFingerprints(privateKeyPath string) ([]string, error) {
+	b, err := ioutil.ReadFile(privateKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ssh.ParseRawPrivateKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("error reading private key %v: %v", privateKeyPath, err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key %v is not an RSA key, the only type supported by AWS", privateKeyPath)
+	}
+	imported, err := importedFingerprint(&rsaKey.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	created, err := createdFingerprint(rsaKey)
+	if err != nil {
+		return nil, err
+	}
+	return []string{imported, created}, nil
+}
+
+// publicKeyFingerprint returns the fingerprint AWS gives to the public key when imported, from
+// its authorized_keys format.
+func publicKeyFingerprint(b []byte, publicKeyPath string) (string, error) {
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(b)
+	if err != nil {
+		return "", fmt.Errorf("error reading public key %v: %v", publicKeyPath, err)
+	}
+	cryptoPub, ok := pub.(ssh.CryptoPublicKey)
+	if !ok || pub.Type() != ssh.KeyAlgoRSA {
+		return "", fmt.Errorf("public key %v is not an RSA key, the only type supported by AWS", publicKeyPath)
+	}
+	return importedFingerprint(cryptoPub.CryptoPublicKey())
+}
+
+// describeKeypair returns the key pair with the configured name, or nil if it does not exist
+func (c *Client) describeKeypair() (*ec2.KeyPairInfo, error) {
+	client, err := c.getAPIClient()
+	if err != nil {
+		return nil, err
+	}
+	a, err := client.DescribeKeyPairs(&ec2.DescribeKeyPairsInput{
+		KeyNames: []*string{aws.String(c.Config.Keyname)},
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "InvalidKeyPair.NotFound" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(a.KeyPairs) == 0 {
+		return nil, nil
+	}
+	return a.KeyPairs[0], nil
+}
+
+// CheckKeypair makes sure the key pair exists and matches the private key. When the private key
+// cannot be read, e.g. because it is encrypted, the public key next to it is used instead.
+func (c *Client) CheckKeypair(privateKeyPath string) error {
+	kp, err := c.describeKeypair()
+	if err != nil {
+		return err
+	}
+	if kp == nil {
+		return fmt.Errorf("keypair %q does not exist, use -f to create it or --import-public-key to import the public key of %v", c.Config.Keyname, privateKeyPath)
+	}
+	local, err := privateKeyFingerprints(privateKeyPath)
+	if err != nil {
+		b, perr := ioutil.ReadFile(privateKeyPath + ".pub")
+		if perr != nil {
+			return fmt.Errorf("cannot compare keypair %v with the private key: %v", c.Config.Keyname, err)
+		}
+		f, perr := publicKeyFingerprint(b, privateKeyPath+".pub")
+		if perr != nil {
+			return fmt.Errorf("cannot compare keypair %v with the private key: %v", c.Config.Keyname, perr)
+		}
+		local = []string{f}
+	}
+	remote := aws.StringValue(kp.KeyFingerprint)
+	for _, f := range local {
+		if f == remote {
+			return nil
+		}
+	}
+	return fmt.Errorf("keypair %q has fingerprint %v, which does not match private key %v (%v)", c.Config.Keyname, remote, privateKeyPath, local[0])
+}
+
+// ImportKeypair imports the public key as the key pair, unless the key pair already exists, in
+// which case its fingerprint must match the public key. The public key is derived from the
+// private key when the path is derivePublicKey, and written next to it unless a matching public
+// key is already there.
+func (c *Client) ImportKeypair(publicKeyPath, privateKeyPath string) error {
+	if publicKeyPath == derivePublicKey {
+		if _, err := os.Stat(privateKeyPath); err != nil {
+			return fmt.Errorf("cannot derive the public key to import: %v", err)
+		}
+		privateKey, err := utils.LoadOrCreatePrivateSSHKey(privateKeyPath)
+		if err != nil {
+			return fmt.Errorf("cannot derive the public key to import from %v: %v", privateKeyPath, err)
+		}
+		publicKeyPath = privateKeyPath + ".pub"
+		if err := writePublicKey(privateKey, publicKeyPath); err != nil {
+			return err
+		}
+	}
+
+	b, err := ioutil.ReadFile(publicKeyPath)
+	if err != nil {
+		return err
+	}
+	local, err := publicKeyFingerprint(b, publicKeyPath)
+	if err != nil {
+		return err
+	}
+
+	// The nodes are reached with the private key, so it has to go with the public key
+	matching := []string{local}
+	if fingerprints, err := privateKeyFingerprints(privateKeyPath); err == nil {
+		if fingerprints[0] != local {
+			return fmt.Errorf("public key %v does not match private key %v", publicKeyPath, privateKeyPath)
+		}
+		matching = fingerprints
+	}
+
+	kp, err := c.describeKeypair()
+	if err != nil {
+		return err
+	}
+	if kp != nil {
+		remote := aws.StringValue(kp.KeyFingerprint)
+		for _, f := range matching {
+			if f == remote {
+				fmt.Printf("Found keypair %v\n", remote)
+				return nil
+			}
+		}
+		return fmt.Errorf("keypair %q already exists with fingerprint %v, which does not match public key %v (%v). Set AWS_KEY_NAME to import it under another name", c.Config.Keyname, remote, publicKeyPath, local)
+	}
+
+	client, err := c.getAPIClient()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Importing public key %v as keypair %v\n", publicKeyPath, c.Config.Keyname)
+	_, err = client.ImportKeyPair(&ec2.ImportKeyPairInput{
+		KeyName:           aws.String(c.Config.Keyname),
+		PublicKeyMaterial: b,
+	})
+	return err
+}
+
+// writePublicKey writes the public key of the private key to the path, unless the file already
+// holds it. Another file is only overwritten when confirmed.
+func writePublicKey(privateKey *rsa.PrivateKey, publicKeyPath string) error {
+	pub, err := ssh.NewPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		return err
+	}
+	if b, err := ioutil.ReadFile(publicKeyPath); err == nil {
+		existing, err := publicKeyFingerprint(b, publicKeyPath)
+		if local, ferr := importedFingerprint(&privateKey.PublicKey); err == nil && ferr == nil && existing == local {
+			fmt.Printf("Using public key %v\n", publicKeyPath)
+			return nil
+		}
+	}
+	f, err := utils.MakeFileAskOnOverwrite(publicKeyPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fmt.Printf("Writing public key to %v\n", publicKeyPath)
+	_, err = f.Write(ssh.MarshalAuthorizedKey(pub))
+	return err
+}
@@ -0,0 +1,231 @@
+package aws
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apprenda/kismatic-provision/provision/plan"
+	"github.com/apprenda/kismatic-provision/provision/retry"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+)
+
+const apiServerPort = 6443
+
+var invalidLBNameChars = regexp.MustCompile("[^a-z0-9-]+")
+
+// lbName returns a unique name for the load balancer of the cluster. Load balancer
+// and target group names are limited to 32 alphanumeric characters and hyphens.
+func lbName(clusterName string) string {
+	name := strings.Trim(invalidLBNameChars.ReplaceAllString(strings.ToLower(clusterName), "-"), "-")
+	if len(name) > 20 {
+		name = strings.Trim(name[:20], "-")
+	}
+	return fmt.Sprintf("%s-api-%s", name, strconv.FormatInt(time.Now().Unix(), 36))
+}
+
+func (c *Client) elbTags() []*elbv2.Tag {
+	thisHost, _ := os.Hostname()
+	return []*elbv2.Tag{
+		{Key: aws.String("ProvisionedBy"), Value: aws.String("Kismatic")},
+		{Key: aws.String("CreatedBy"), Value: aws.String(thisHost)},
+	}
+}
+
+// CreateMasterLoadBalancer creates a network load balancer that forwards the API server
+// port to the master nodes, and waits for it to become active. An internal load balancer
+// is only reachable from within the VPC.
+// The masters are registered by private IP, so that their traffic comes from the load
+// balancer within the VPC, and a master can reach the API server through it.
+// Returns the DNS name of the load balancer.
+func (c *Client) CreateMasterLoadBalancer(clusterName string, masters []plan.Node, internal bool) (string, error) {
+	elb, err := c.getELBClient()
+	if err != nil {
+		return "", err
+	}
+	// A network load balancer takes a single subnet per availability zone
+	pl, err := c.subnetPlacement()
+	if err != nil {
+		return "", err
+	}
+
+	scheme := elbv2.LoadBalancerSchemeEnumInternetFacing
+	if internal {
+		scheme = elbv2.LoadBalancerSchemeEnumInternal
+	}
+	name := lbName(clusterName)
+	fmt.Printf("Creating load balancer %v\n", name)
+	lb, err := elb.CreateLoadBalancer(&elbv2.CreateLoadBalancerInput{
+		Name:    aws.String(name),
+		Type:    aws.String(elbv2.LoadBalancerTypeEnumNetwork),
+		Scheme:  aws.String(scheme),
+		Subnets: aws.StringSlice(pl.zoneSubnets()),
+		Tags:    c.elbTags(),
+	})
+	if err != nil {
+		return "", err
+	}
+	lbARN := lb.LoadBalancers[0].LoadBalancerArn
+
+	tg, err := elb.CreateTargetGroup(&elbv2.CreateTargetGroupInput{
+		Name:                       aws.String(name),
+		Protocol:                   aws.String(elbv2.ProtocolEnumTcp),
+		Port:                       aws.Int64(apiServerPort),
+		VpcId:                      aws.String(pl.vpc),
+		TargetType:                 aws.String(elbv2.TargetTypeEnumIp),
+		HealthCheckProtocol:        aws.String(elbv2.ProtocolEnumTcp),
+		HealthCheckIntervalSeconds: aws.Int64(10),
+		HealthyThresholdCount:      aws.Int64(3),
+		UnhealthyThresholdCount:    aws.Int64(3),
+	})
+	if err != nil {
+		return "", err
+	}
+	tgARN := tg.TargetGroups[0].TargetGroupArn
+	if _, err := elb.AddTags(&elbv2.AddTagsInput{ResourceArns: []*string{tgARN}, Tags: c.elbTags()}); err != nil {
+		fmt.Println("Error tagging new Target Group")
+	}
+
+	targets := []*elbv2.TargetDescription{}
+	for _, m := range masters {
+		targets = append(targets, &elbv2.TargetDescription{Id: aws.String(m.PrivateIPv4)})
+	}
+	if _, err := elb.RegisterTargets(&elbv2.RegisterTargetsInput{TargetGroupArn: tgARN, Targets: targets}); err != nil {
+		return "", err
+	}
+
+	_, err = elb.CreateListener(&elbv2.CreateListenerInput{
+		LoadBalancerArn: lbARN,
+		Protocol:        aws.String(elbv2.ProtocolEnumTcp),
+		Port:            aws.Int64(apiServerPort),
+		DefaultActions: []*elbv2.Action{
+			{
+				Type:           aws.String(elbv2.ActionTypeEnumForward),
+				TargetGroupArn: tgARN,
+			},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Println("Waiting for the load balancer to become active")
+	err = elb.WaitUntilLoadBalancerAvailable(&elbv2.DescribeLoadBalancersInput{
+		LoadBalancerArns: []*string{lbARN},
+	})
+	if err != nil {
+		return "", err
+	}
+	return *lb.LoadBalancers[0].DNSName, nil
+}
+
+// taggedELBResources returns the ARNs of the resources that were created by this tool
+// from this machine.
+func (c *Client) taggedELBResources(arns []*string) ([]*string, error) {
+	elb, err := c.getELBClient()
+	if err != nil {
+		return nil, err
+	}
+	thisHost, _ := os.Hostname()
+	tagged := []*string{}
+	// DescribeTags accepts up to 20 resources per call
+	for i := 0; i < len(arns); i += 20 {
+		end := i + 20
+		if end > len(arns) {
+			end = len(arns)
+		}
+		res, err := elb.DescribeTags(&elbv2.DescribeTagsInput{ResourceArns: arns[i:end]})
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range res.TagDescriptions {
+			var provisioned, created bool
+			for _, t := range d.Tags {
+				switch *t.Key {
+				case "ProvisionedBy":
+					provisioned = *t.Value == "Kismatic"
+				case "CreatedBy":
+					created = *t.Value == thisHost
+				}
+			}
+			if provisioned && created {
+				tagged = append(tagged, d.ResourceArn)
+			}
+		}
+	}
+	return tagged, nil
+}
+
+// DeleteLoadBalancers deletes the load balancers and target groups that were created
+// by this tool from this machine.
+func (c *Client) DeleteLoadBalancers() error {
+	elb, err := c.getELBClient()
+	if err != nil {
+		return err
+	}
+
+	lbs := []*string{}
+	err = elb.DescribeLoadBalancersPages(&elbv2.DescribeLoadBalancersInput{}, func(page *elbv2.DescribeLoadBalancersOutput, last bool) bool {
+		for _, lb := range page.LoadBalancers {
+			lbs = append(lbs, lb.LoadBalancerArn)
+		}
+		return true
+	})
+	// Without access to the load balancers, none can have been created either
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "AccessDenied" {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	lbs, err = c.taggedELBResources(lbs)
+	if err != nil {
+		return err
+	}
+	for _, arn := range lbs {
+		fmt.Printf("Deleting load balancer %v\n", *arn)
+		if _, err := elb.DeleteLoadBalancer(&elbv2.DeleteLoadBalancerInput{LoadBalancerArn: arn}); err != nil {
+			return err
+		}
+	}
+	if len(lbs) > 0 {
+		if err := elb.WaitUntilLoadBalancersDeleted(&elbv2.DescribeLoadBalancersInput{LoadBalancerArns: lbs}); err != nil {
+			return err
+		}
+	}
+
+	tgs := []*string{}
+	err = elb.DescribeTargetGroupsPages(&elbv2.DescribeTargetGroupsInput{}, func(page *elbv2.DescribeTargetGroupsOutput, last bool) bool {
+		for _, tg := range page.TargetGroups {
+			tgs = append(tgs, tg.TargetGroupArn)
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	tgs, err = c.taggedELBResources(tgs)
+	if err != nil {
+		return err
+	}
+	for _, arn := range tgs {
+		fmt.Printf("Deleting target group %v\n", *arn)
+		// The target group stays in use for a little while after its load balancer is deleted
+		err := retry.WithBackoff(5, func() error {
+			_, err := elb.DeleteTargetGroup(&elbv2.DeleteTargetGroupInput{TargetGroupArn: arn})
+			if aerr, ok := err.(awserr.Error); ok && aerr.Code() == elbv2.ErrCodeTargetGroupNotFoundException {
+				return nil
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -4,9 +4,14 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/apprenda/kismatic-provision/provision/plan"
+	"github.com/apprenda/kismatic-provision/provision/remote"
+	"github.com/apprenda/kismatic-provision/provision/userdata"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
 )
 
 const (
@@ -16,6 +21,9 @@ const (
 
 	AWSTargetRegion = "us-east-1"
 	AWSKeyName      = "kismatic-integration-testing"
+
+	// nodeTimeout is how long to wait for new instances to be running
+	nodeTimeout = 10 * time.Minute
 )
 
 type infrastructureProvisioner interface {
@@ -25,7 +33,7 @@ type infrastructureProvisioner interface {
 
 	TerminateAllNodes() error
 
-	ForceProvision() error
+	ForceProvision(NetworkOpts, SecurityGroupOpts) error
 
 	SSHKey() string
 }
@@ -33,19 +41,21 @@ type infrastructureProvisioner interface {
 type LinuxDistro string
 
 type NodeCount struct {
-	Etcd   uint16
-	Master uint16
-	Worker uint16
+	Etcd      uint16
+	Master    uint16
+	Worker    uint16
+	Bootstrap uint16
 }
 
 func (nc NodeCount) Total() uint16 {
-	return nc.Etcd + nc.Master + nc.Worker
+	return nc.Etcd + nc.Master + nc.Worker + nc.Bootstrap
 }
 
 type ProvisionedNodes struct {
-	Etcd   []plan.Node
-	Master []plan.Node
-	Worker []plan.Node
+	Etcd      []plan.Node
+	Master    []plan.Node
+	Worker    []plan.Node
+	Bootstrap []plan.Node
 }
 
 func (p ProvisionedNodes) allNodes() []plan.Node {
@@ -53,9 +63,31 @@ func (p ProvisionedNodes) allNodes() []plan.Node {
 	n = append(n, p.Etcd...)
 	n = append(n, p.Master...)
 	n = append(n, p.Worker...)
+	n = append(n, p.Bootstrap...)
 	return n
 }
 
+// setBastion makes the etcd, master and worker nodes reachable through the bastion
+func (p *ProvisionedNodes) setBastion(bastion string) {
+	for _, nodes := range [][]plan.Node{p.Etcd, p.Master, p.Worker} {
+		for i := range nodes {
+			nodes[i].Bastion = bastion
+		}
+	}
+}
+
+func (p ProvisionedNodes) cluster() remote.Cluster {
+	c := remote.Cluster{
+		"etcd":   p.Etcd,
+		"master": p.Master,
+		"worker": p.Worker,
+	}
+	if len(p.Bootstrap) > 0 {
+		c["bootstrap"] = p.Bootstrap
+	}
+	return c
+}
+
 type sshMachineProvisioner struct {
 	sshKey string
 }
@@ -69,21 +101,27 @@ type awsProvisioner struct {
 	client *Client
 }
 
-func AWSClientFromEnvironment() (*awsProvisioner, bool) {
-	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
-	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
-	if accessKeyID == "" || secretAccessKey == "" {
-		return nil, false
-	}
-	c := Client{
-		Config: &ClientConfig{
-			Region:  AWSTargetRegion,
-			Keyname: AWSKeyName,
-		},
+// AWSClientFromEnvironment returns a provisioner configured from the environment. The
+// credentials are only looked up when the API is first used.
+func AWSClientFromEnvironment() *awsProvisioner {
+	p := awsProvisioner{client: &Client{
 		Credentials: Credentials{
-			ID:     accessKeyID,
-			Secret: secretAccessKey,
+			Profile:       os.Getenv("AWS_PROFILE"),
+			AssumeRoleARN: os.Getenv("AWS_ASSUME_ROLE_ARN"),
 		},
+	}}
+	p.configFromEnvironment()
+	return &p
+}
+
+// configFromEnvironment reads the configuration of the client from the environment again,
+// which is updated while preparing the infrastructure. The session of the client is kept,
+// so that the credentials are only looked up, and the role assumed, once.
+func (p *awsProvisioner) configFromEnvironment() {
+	c := p.client
+	c.Config = &ClientConfig{
+		Region:  AWSTargetRegion,
+		Keyname: AWSKeyName,
 	}
 	overrideRegion := os.Getenv("AWS_TARGET_REGION")
 	if overrideRegion != "" {
@@ -91,23 +129,62 @@ func AWSClientFromEnvironment() (*awsProvisioner, bool) {
 	}
 	overrideSubnet := os.Getenv("AWS_SUBNET_ID")
 	if overrideSubnet != "" {
-		c.Config.SubnetID = overrideSubnet
+		c.Config.SubnetIDs = subnetsFromEnv(overrideSubnet)
+		c.Config.SubnetID = c.Config.SubnetIDs[0]
 	}
 	overrideSecGroup := os.Getenv("AWS_SECURITY_GROUP_ID")
 	if overrideSecGroup != "" {
 		c.Config.SecurityGroupID = overrideSecGroup
 	}
+	c.Config.RoleSecurityGroupIDs = roleSecurityGroupsFromEnv()
 	overrideKeyName := os.Getenv("AWS_KEY_NAME")
 	if overrideKeyName != "" {
 		c.Config.Keyname = overrideKeyName
 	}
-	p := awsProvisioner{client: &c}
 	p.sshKey = os.Getenv("AWS_SSH_KEY_PATH")
 	if p.sshKey == "" {
 		dir, _ := os.Getwd()
 		p.sshKey = filepath.Join(dir, "kismatic.pem")
 	}
-	return &p, true
+}
+
+// Cluster returns the nodes provisioned by this tool from this machine, grouped by role.
+// Nodes without a public IP are reached through the bastion or, if it is empty, through
+// the bootstrap node.
+func (p awsProvisioner) Cluster(bastion string) (remote.Cluster, error) {
+	nodes, err := p.client.ListNodes()
+	if err != nil {
+		return nil, err
+	}
+	if bastion == "" {
+		for _, n := range nodes {
+			if n.Role == "bootstrap" && n.PublicIP != "" {
+				bastion = n.PublicIP
+				break
+			}
+		}
+	}
+	cluster := remote.Cluster{}
+	for _, n := range nodes {
+		role := n.Role
+		if role == "" {
+			role = "unknown"
+		}
+		node := plan.Node{
+			ID:          n.ID,
+			Host:        n.PrivateDNSName,
+			PublicIPv4:  n.PublicIP,
+			PrivateIPv4: n.PrivateIP,
+			SSHUser:     n.SSHUser,
+			Zone:        n.Zone,
+		}
+		if node.PublicIPv4 == "" {
+			node.PublicIPv4 = n.PrivateIP
+			node.Bastion = bastion
+		}
+		cluster[role] = append(cluster[role], node)
+	}
+	return cluster, nil
 }
 
 func (p awsProvisioner) TerminateAllNodes() error {
@@ -122,7 +199,7 @@ func (p awsProvisioner) TerminateAllNodes() error {
 	return nil
 }
 
-func (p *awsProvisioner) ForceProvision() error {
+func (p *awsProvisioner) ForceProvision(netOpts NetworkOpts, sgOpts SecurityGroupOpts) error {
 	if _, err := os.Stat(p.sshKey); os.IsNotExist(err) {
 		if err := p.client.MaybeProvisionKeypair(p.sshKey); err != nil {
 			return err
@@ -130,43 +207,71 @@ func (p *awsProvisioner) ForceProvision() error {
 	}
 
 	if p.client.Config.SubnetID == "" || p.client.Config.SecurityGroupID == "" {
-		vpc, err := p.client.MaybeProvisionVPC()
-		if err != nil {
-			return err
-		}
-
-		//maybe provision subnet
-		sn, err := p.client.MaybeProvisionSubnet(vpc)
-		if err != nil {
-			return err
-		}
-
-		//maybe provision internet gateway
-		ig, err := p.client.MaybeProvisionIG(vpc)
-		if err != nil {
-			return err
-		}
-
-		//maybe provision Routes
-		_, err = p.client.MaybeProvisionRoute(vpc, ig, sn)
-		if err != nil {
-			return err
+		var vpc string
+		var sn []string
+		if p.client.Config.SubnetID != "" {
+			// The subnets were given, only the security groups are provisioned in their VPC
+			pl, err := p.client.subnetPlacement()
+			if err != nil {
+				return err
+			}
+			vpc = pl.vpc
+			sn = p.client.Config.SubnetIDs
+		} else {
+			var err error
+			vpc, err = p.client.MaybeProvisionVPC(netOpts.VPCCIDR)
+			if err != nil {
+				return err
+			}
+
+			//maybe provision a subnet per availability zone
+			sn, err = p.client.MaybeProvisionSubnets(vpc, netOpts)
+			if err != nil {
+				return err
+			}
+
+			//maybe provision internet gateway
+			ig, err := p.client.MaybeProvisionIG(vpc)
+			if err != nil {
+				return err
+			}
+
+			//maybe provision Routes
+			_, err = p.client.MaybeProvisionRoute(vpc, ig, sn)
+			if err != nil {
+				return err
+			}
 		}
 
 		//maybe provision SGs
-		sg, err := p.client.MaybeProvisionSGs(vpc)
-		if err != nil {
-			return err
+		if sgOpts.LegacyOpen {
+			sg, err := p.client.MaybeProvisionSGs(vpc)
+			if err != nil {
+				return err
+			}
+			os.Setenv("AWS_SECURITY_GROUP_ID", sg)
+		} else {
+			cidrs, err := sgOpts.allowedCIDRs()
+			if err != nil {
+				return err
+			}
+			groups, err := p.client.MaybeProvisionRoleSGs(vpc, cidrs)
+			if err != nil {
+				return err
+			}
+			os.Setenv("AWS_SECURITY_GROUP_ID", groups[clusterSG])
+			for _, role := range securityGroupRoles {
+				os.Setenv(securityGroupEnv(role), groups[role])
+			}
 		}
 
-		os.Setenv("AWS_SUBNET_ID", sn)
-		os.Setenv("AWS_SECURITY_GROUP_ID", sg)
+		os.Setenv("AWS_SUBNET_ID", strings.Join(sn, ","))
 	}
 
 	return nil
 }
 
-func (p awsProvisioner) ProvisionNodes(blueprint NodeBlueprint, nodeCount NodeCount, distro LinuxDistro) (ProvisionedNodes, error) {
+func (p awsProvisioner) ProvisionNodes(blueprint NodeBlueprint, nodeCount NodeCount, distro LinuxDistro, clusterName string, userData userdata.Opts) (ProvisionedNodes, error) {
 	var ami AMI
 	switch distro {
 	case Ubuntu1604LTS:
@@ -178,68 +283,166 @@ func (p awsProvisioner) ProvisionNodes(blueprint NodeBlueprint, nodeCount NodeCo
 	default:
 		panic(fmt.Sprintf("Used an unsupported distribution: %s", distro))
 	}
-	provisioned := ProvisionedNodes{}
-	var i uint16
-	for i = 0; i < nodeCount.Etcd; i++ {
-		nodeID, err := p.client.CreateNode(ami, blueprint.EtcdInstanceType, blueprint.EtcdDisk)
-		if err != nil {
-			return provisioned, err
-		}
-		provisioned.Etcd = append(provisioned.Etcd, plan.Node{ID: nodeID})
+	// A cluster without etcd and master nodes is a minikube, where the worker plays every role
+	workerRoles := []string{"worker"}
+	if nodeCount.Etcd == 0 && nodeCount.Master == 0 {
+		workerRoles = []string{"etcd", "master", "worker"}
 	}
-	for i = 0; i < nodeCount.Master; i++ {
-		nodeID, err := p.client.CreateNode(ami, blueprint.MasterInstanceType, blueprint.MasterDisk)
-		if err != nil {
-			return provisioned, err
+	sg := p.client.Config.SecurityGroups
+	// The nodes of each role are spread across the availability zones of the subnets
+	pl, err := p.client.subnetPlacement()
+	if err != nil {
+		return ProvisionedNodes{}, err
+	}
+	if id := p.client.Config.BootstrapSubnetID; id != "" && nodeCount.Bootstrap > 0 {
+		if err := p.client.checkSubnetVPC(id, pl.vpc); err != nil {
+			return ProvisionedNodes{}, err
 		}
-		provisioned.Master = append(provisioned.Master, plan.Node{ID: nodeID})
 	}
-	for i = 0; i < nodeCount.Worker; i++ {
-		nodeID, err := p.client.CreateNode(ami, blueprint.WorkerInstanceType, blueprint.WorkerDisk)
+	templates, err := userData.Templates()
+	if err != nil {
+		return ProvisionedNodes{}, err
+	}
+	// create launches the nodes of a role, giving the i-th node the security groups of the given roles
+	create := func(role string, count uint16, instanceType InstanceType, disk int64, sgRoles func(i int) []string) ([]plan.Node, error) {
+		specs := []NodeSpec{}
+		for i := 0; i < int(count); i++ {
+			ud, err := templates.Render(clusterName, role, i)
+			if err != nil {
+				return nil, err
+			}
+			subnet := pl.subnet(i)
+			if role == "bootstrap" && p.client.Config.BootstrapSubnetID != "" {
+				subnet = p.client.Config.BootstrapSubnetID
+			}
+			specs = append(specs, NodeSpec{UserData: ud, Subnet: subnet, SecurityGroups: sg(sgRoles(i)...)})
+		}
+		ids, err := p.client.CreateNodes(ami, instanceType, disk, role, specs)
 		if err != nil {
-			return provisioned, err
+			return nil, err
 		}
-		provisioned.Worker = append(provisioned.Worker, plan.Node{ID: nodeID})
-	}
-	// Wait until all instances have their public IPs assigned
-	for i := range provisioned.Etcd {
-		etcd := &provisioned.Etcd[i]
-		if err := p.updateNodeWithDeets(etcd.ID, etcd); err != nil {
-			return provisioned, err
+		nodes := []plan.Node{}
+		for _, id := range ids {
+			nodes = append(nodes, plan.Node{ID: id})
 		}
+		return nodes, nil
 	}
-	for i := range provisioned.Master {
-		master := &provisioned.Master[i]
-		if err := p.updateNodeWithDeets(master.ID, master); err != nil {
-			return provisioned, err
-		}
+	provisioned := ProvisionedNodes{}
+	provisioned.Etcd, err = create("etcd", nodeCount.Etcd, blueprint.EtcdInstanceType, blueprint.EtcdDisk, func(int) []string {
+		return []string{"etcd"}
+	})
+	if err != nil {
+		return provisioned, err
+	}
+	provisioned.Master, err = create("master", nodeCount.Master, blueprint.MasterInstanceType, blueprint.MasterDisk, func(int) []string {
+		return []string{"master"}
+	})
+	if err != nil {
+		return provisioned, err
 	}
-	for i := range provisioned.Worker {
-		worker := &provisioned.Worker[i]
-		if err := p.updateNodeWithDeets(worker.ID, worker); err != nil {
-			return provisioned, err
+	provisioned.Worker, err = create("worker", nodeCount.Worker, blueprint.WorkerInstanceType, blueprint.WorkerDisk, func(i int) []string {
+		// The first worker is the ingress node of the plan
+		if i == 0 {
+			return append(workerRoles, "ingress")
 		}
+		return workerRoles
+	})
+	if err != nil {
+		return provisioned, err
+	}
+	provisioned.Bootstrap, err = create("bootstrap", nodeCount.Bootstrap, blueprint.EtcdInstanceType, blueprint.EtcdDisk, func(int) []string {
+		return nil
+	})
+	if err != nil {
+		return provisioned, err
+	}
+	// Wait until all instances are running and have their IPs assigned
+	if err := p.waitForNodes(&provisioned); err != nil {
+		return provisioned, err
 	}
 	fmt.Println()
 	return provisioned, nil
 }
 
-func (p awsProvisioner) updateNodeWithDeets(nodeID string, node *plan.Node) error {
+// waitForNodes waits until all the nodes are running and their addresses are known. The
+// nodes are described in a single call per poll. It fails as soon as a node stops, with
+// the reason given by AWS. A node without a public IP is addressed by its private IP
+// everywhere, including the plan file.
+func (p awsProvisioner) waitForNodes(provisioned *ProvisionedNodes) error {
+	roles := []struct {
+		name  string
+		nodes []plan.Node
+	}{
+		{"etcd", provisioned.Etcd},
+		{"master", provisioned.Master},
+		{"worker", provisioned.Worker},
+		{"bootstrap", provisioned.Bootstrap},
+	}
+	ids := []string{}
+	for _, r := range roles {
+		for _, n := range r.nodes {
+			ids = append(ids, n.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	timeout := time.After(nodeTimeout)
 	for {
 		fmt.Print(".")
-		awsNode, err := p.client.GetNode(nodeID)
+		awsNodes, err := p.client.DescribeNodes(ids)
+		// New instances can take a moment to be visible to DescribeInstances
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "InvalidInstanceID.NotFound" {
+			awsNodes, err = map[string]Node{}, nil
+		}
 		if err != nil {
 			return err
 		}
-		node.PublicIPv4 = awsNode.PublicIP
-		node.PrivateIPv4 = awsNode.PrivateIP
-		node.SSHUser = awsNode.SSHUser
 
-		node.Host = awsNode.PrivateDNSName
-		if node.PublicIPv4 != "" && node.Host != "" && node.PrivateIPv4 != "" {
+		ready := true
+		failed := []string{}
+		for _, r := range roles {
+			public := p.client.Config.hasPublicIP(r.name)
+			for i := range r.nodes {
+				node := &r.nodes[i]
+				awsNode, ok := awsNodes[node.ID]
+				if !ok {
+					ready = false
+					continue
+				}
+				switch awsNode.State {
+				case ec2.InstanceStateNameShuttingDown, ec2.InstanceStateNameTerminated, ec2.InstanceStateNameStopping, ec2.InstanceStateNameStopped:
+					failed = append(failed, fmt.Sprintf("%s node %s is %s: %s", r.name, node.ID, awsNode.State, awsNode.StateReason))
+					continue
+				}
+				node.PublicIPv4 = awsNode.PublicIP
+				node.PrivateIPv4 = awsNode.PrivateIP
+				node.SSHUser = awsNode.SSHUser
+				node.Host = awsNode.PrivateDNSName
+				node.Zone = awsNode.Zone
+				if !public {
+					node.PublicIPv4 = awsNode.PrivateIP
+				}
+				if awsNode.State != ec2.InstanceStateNameRunning || node.PublicIPv4 == "" || node.Host == "" || node.PrivateIPv4 == "" {
+					ready = false
+				}
+			}
+		}
+		if len(failed) > 0 {
+			fmt.Println()
+			return fmt.Errorf("instances failed to start, use delete-all to clean up the others:\n%s", strings.Join(failed, "\n"))
+		}
+		if ready {
 			return nil
 		}
-		time.Sleep(5 * time.Second)
+
+		select {
+		case <-timeout:
+			fmt.Println()
+			return fmt.Errorf("timed out after %v waiting for the instances to be running", nodeTimeout)
+		case <-time.After(5 * time.Second):
+		}
 	}
 }
 
@@ -255,7 +458,7 @@ func (p awsProvisioner) TerminateNodes(runningNodes ProvisionedNodes) error {
 func WaitForSSH(ProvisionedNodes ProvisionedNodes, sshKey string) error {
 	nodes := ProvisionedNodes.allNodes()
 	for _, n := range nodes {
-		BlockUntilSSHOpen(n.PublicIPv4, n.SSHUser, sshKey)
+		BlockUntilSSHOpen(n, sshKey)
 	}
 	fmt.Println()
 	return nil
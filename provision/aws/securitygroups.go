@@ -0,0 +1,394 @@
+package aws
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/apprenda/kismatic-provision/provision/plan"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/spf13/cobra"
+)
+
+// clusterSG is the security group shared by all the nodes of the cluster. The
+// rules of the role groups only accept traffic coming from members of this group.
+const clusterSG = "cluster"
+
+// The descriptions of the IP ranges that are managed by the tool, which tell apart the
+// allowed CIDRs from the nodes when the ranges are revoked.
+const (
+	allowedCIDRDescription = "Kismatic allowed CIDR"
+	nodeIPDescription      = "Kismatic node"
+)
+
+// securityGroupRoles are the roles that get their own security group, in addition
+// to the cluster group.
+var securityGroupRoles = []string{"etcd", "master", "worker", "ingress"}
+
+// sgRule opens a port range to the members of a security group, or to the allowed
+// CIDRs when the source is empty.
+type sgRule struct {
+	protocol string
+	from     int64
+	to       int64
+	source   string
+}
+
+var securityGroupRules = map[string][]sgRule{
+	clusterSG: {
+		{protocol: "tcp", from: 22, to: 22},
+		{protocol: "tcp", from: 22, to: 22, source: clusterSG},
+		{protocol: "tcp", from: 10250, to: 10250, source: clusterSG}, // kubelet
+		{protocol: "tcp", from: 179, to: 179, source: clusterSG},     // calico BGP
+		{protocol: "4", source: clusterSG},                           // calico IP-in-IP
+	},
+	"etcd": {
+		{protocol: "tcp", from: 2379, to: 2380, source: clusterSG}, // kubernetes etcd
+		{protocol: "tcp", from: 6660, to: 6660, source: clusterSG}, // networking etcd peers
+		{protocol: "tcp", from: 6666, to: 6666, source: clusterSG}, // networking etcd clients
+	},
+	"master": {
+		{protocol: "tcp", from: 6443, to: 6443},
+		{protocol: "tcp", from: 6443, to: 6443, source: clusterSG},
+	},
+	"worker": {
+		{protocol: "tcp", from: 30000, to: 32767, source: clusterSG}, // node ports
+	},
+	"ingress": {
+		{protocol: "tcp", from: 80, to: 80},
+		{protocol: "tcp", from: 443, to: 443},
+	},
+}
+
+// SecurityGroupOpts control the security groups created when force provisioning
+type SecurityGroupOpts struct {
+	AllowedCIDRs []string
+	LegacyOpen   bool
+}
+
+func addSecurityGroupFlags(cmd *cobra.Command, opts *SecurityGroupOpts) {
+	cmd.Flags().StringSliceVar(&opts.AllowedCIDRs, "allowed-cidr", []string{}, "CIDR allowed to reach the nodes over SSH, the API server and the ingress ports, when force provisioning security groups. Defaults to the public IP of this machine. Can be repeated.")
+	cmd.Flags().BoolVar(&opts.LegacyOpen, "legacy-open-sg", false, "If present, force provisioning opens the default security group of the VPC to all traffic instead of creating a security group per role.")
+}
+
+// allowedCIDRs returns the CIDRs from the options, or the public IP of this machine
+func (opts SecurityGroupOpts) allowedCIDRs() ([]string, error) {
+	for _, c := range opts.AllowedCIDRs {
+		if _, _, err := net.ParseCIDR(c); err != nil {
+			return nil, fmt.Errorf("%q is not a valid CIDR for --allowed-cidr", c)
+		}
+	}
+	if len(opts.AllowedCIDRs) > 0 {
+		return opts.AllowedCIDRs, nil
+	}
+	ip, err := publicIP()
+	if err != nil {
+		return nil, fmt.Errorf("error finding the public IP of this machine, use --allowed-cidr instead: %v", err)
+	}
+	fmt.Printf("Allowing access from %s\n", ip)
+	return []string{ip + "/32"}, nil
+}
+
+func publicIP() (string, error) {
+	resp, err := http.Get("https://checkip.amazonaws.com")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	ip := net.ParseIP(strings.TrimSpace(string(b)))
+	if ip == nil || ip.To4() == nil {
+		return "", fmt.Errorf("unexpected response %q", strings.TrimSpace(string(b)))
+	}
+	return ip.String(), nil
+}
+
+// securityGroupEnv returns the environment variable holding the ID of the security group of the role
+func securityGroupEnv(role string) string {
+	return "AWS_" + strings.ToUpper(role) + "_SECURITY_GROUP_ID"
+}
+
+// roleSecurityGroupsFromEnv returns the security groups of the roles that are set in the environment
+func roleSecurityGroupsFromEnv() map[string]string {
+	groups := map[string]string{}
+	for _, role := range securityGroupRoles {
+		if id := os.Getenv(securityGroupEnv(role)); id != "" {
+			groups[role] = id
+		}
+	}
+	return groups
+}
+
+// SecurityGroups returns the security groups of a node that plays the given roles
+func (c ClientConfig) SecurityGroups(roles ...string) []string {
+	groups := []string{c.SecurityGroupID}
+	for _, r := range roles {
+		if id, ok := c.RoleSecurityGroupIDs[r]; ok {
+			groups = append(groups, id)
+		}
+	}
+	return groups
+}
+
+// MaybeProvisionRoleSGs creates the cluster security group and a security group per role
+// in the VPC, if they do not exist, and makes sure they allow the required traffic.
+// Returns the IDs of the groups, keyed by role.
+func (c *Client) MaybeProvisionRoleSGs(vpc string, allowedCIDRs []string) (map[string]string, error) {
+	client, err := c.getAPIClient()
+	if err != nil {
+		return nil, err
+	}
+
+	vpcs, err := client.DescribeVpcs(&ec2.DescribeVpcsInput{VpcIds: []*string{aws.String(vpc)}})
+	if err != nil {
+		return nil, err
+	}
+	if len(vpcs.Vpcs) != 1 {
+		return nil, fmt.Errorf("VPC %q was not found", vpc)
+	}
+	vpcCIDR := vpcs.Vpcs[0].CidrBlock
+
+	groups := map[string]string{}
+	for _, role := range append([]string{clusterSG}, securityGroupRoles...) {
+		name := "kismatic-" + role
+		a, err := client.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
+			Filters: []*ec2.Filter{
+				&ec2.Filter{
+					Name:   aws.String("vpc-id"),
+					Values: []*string{aws.String(vpc)},
+				},
+				&ec2.Filter{
+					Name:   aws.String("group-name"),
+					Values: []*string{aws.String(name)},
+				},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(a.SecurityGroups) > 0 {
+			fmt.Printf("Found Security Group %v\n", *a.SecurityGroups[0].GroupId)
+			groups[role] = *a.SecurityGroups[0].GroupId
+			continue
+		}
+
+		fmt.Printf("Creating new Security Group %v\n", name)
+		a2, err := client.CreateSecurityGroup(&ec2.CreateSecurityGroupInput{
+			Description: aws.String(fmt.Sprintf("Kismatic %s nodes", role)),
+			GroupName:   aws.String(name),
+			VpcId:       aws.String(vpc),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := c.tagResourceProvisionedBy(a2.GroupId); err != nil {
+			fmt.Println("Error tagging new Security Group")
+		}
+		c.TagResourceName(a2.GroupId, fmt.Sprintf("Kismatic %s SG", strings.Title(role)))
+		groups[role] = *a2.GroupId
+	}
+
+	// The rules are authorized on every run, so that the groups pick up a change in the
+	// allowed CIDRs. Rules that already exist are skipped, and the ranges that are no
+	// longer allowed are revoked.
+	for role, rules := range securityGroupRules {
+		for _, r := range rules {
+			perm := &ec2.IpPermission{IpProtocol: aws.String(r.protocol)}
+			if r.protocol == "tcp" || r.protocol == "udp" {
+				perm.FromPort = aws.Int64(r.from)
+				perm.ToPort = aws.Int64(r.to)
+			}
+			switch r.source {
+			case "":
+				for _, cidr := range allowedCIDRs {
+					perm.IpRanges = append(perm.IpRanges, &ec2.IpRange{CidrIp: aws.String(cidr), Description: aws.String(allowedCIDRDescription)})
+				}
+			default:
+				perm.UserIdGroupPairs = []*ec2.UserIdGroupPair{{GroupId: aws.String(groups[r.source])}}
+			}
+			if err := c.authorizeIngress(groups[role], perm); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := c.revokeStaleRanges(vpc, aws.StringValue(vpcCIDR), groups, allowedCIDRs); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// revokeStaleRanges revokes the ranges of the rules open to the allowed CIDRs that are no
+// longer allowed, and the ranges opened to nodes that no longer exist in the VPC. Ranges
+// without a description were authorized before they were described, and are taken to be
+// allowed CIDRs unless they are the range of the VPC.
+func (c *Client) revokeStaleRanges(vpc, vpcCIDR string, groups map[string]string, allowedCIDRs []string) error {
+	client, err := c.getAPIClient()
+	if err != nil {
+		return err
+	}
+	current := map[string]bool{vpcCIDR: true}
+	for _, cidr := range allowedCIDRs {
+		current[cidr] = true
+	}
+	nodeIPs := map[string]bool{}
+	err = client.DescribeInstancesPages(&ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("vpc-id"), Values: []*string{aws.String(vpc)}},
+			{Name: aws.String("instance-state-name"), Values: aws.StringSlice([]string{"pending", "running", "stopping", "stopped"})},
+		},
+	}, func(page *ec2.DescribeInstancesOutput, last bool) bool {
+		for _, r := range page.Reservations {
+			for _, i := range r.Instances {
+				if i.PublicIpAddress != nil {
+					nodeIPs[*i.PublicIpAddress+"/32"] = true
+				}
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	for role, rules := range securityGroupRules {
+		res, err := client.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{GroupIds: []*string{aws.String(groups[role])}})
+		if err != nil {
+			return err
+		}
+		for _, r := range rules {
+			if r.source != "" {
+				continue
+			}
+			for _, perm := range res.SecurityGroups[0].IpPermissions {
+				if aws.StringValue(perm.IpProtocol) != r.protocol || aws.Int64Value(perm.FromPort) != r.from || aws.Int64Value(perm.ToPort) != r.to {
+					continue
+				}
+				for _, ipRange := range perm.IpRanges {
+					cidr := aws.StringValue(ipRange.CidrIp)
+					switch aws.StringValue(ipRange.Description) {
+					case allowedCIDRDescription, "":
+						if current[cidr] {
+							continue
+						}
+					case nodeIPDescription:
+						if nodeIPs[cidr] {
+							continue
+						}
+					default:
+						continue
+					}
+					fmt.Printf("Revoking access from %v to port %d of Security Group %v\n", cidr, r.from, groups[role])
+					_, err := client.RevokeSecurityGroupIngress(&ec2.RevokeSecurityGroupIngressInput{
+						GroupId: aws.String(groups[role]),
+						IpPermissions: []*ec2.IpPermission{{
+							IpProtocol: perm.IpProtocol,
+							FromPort:   perm.FromPort,
+							ToPort:     perm.ToPort,
+							IpRanges:   []*ec2.IpRange{{CidrIp: ipRange.CidrIp}},
+						}},
+					})
+					if err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// AllowAPIFromNodes opens the API server port of the master security group to the public
+// IPs of the nodes. The nodes reach the API server at the public IP of a master, and
+// traffic to a public IP leaves the VPC, so it comes back from the public IP of the node.
+func (c *Client) AllowAPIFromNodes(nodes []plan.Node) error {
+	group, ok := c.Config.RoleSecurityGroupIDs["master"]
+	if !ok {
+		return nil
+	}
+	perm := &ec2.IpPermission{
+		IpProtocol: aws.String("tcp"),
+		FromPort:   aws.Int64(apiServerPort),
+		ToPort:     aws.Int64(apiServerPort),
+	}
+	for _, n := range nodes {
+		// Private nodes reach the masters within the VPC
+		if n.PublicIPv4 == "" || n.PublicIPv4 == n.PrivateIPv4 {
+			continue
+		}
+		perm.IpRanges = append(perm.IpRanges, &ec2.IpRange{CidrIp: aws.String(n.PublicIPv4 + "/32"), Description: aws.String(nodeIPDescription)})
+	}
+	if len(perm.IpRanges) == 0 {
+		return nil
+	}
+	fmt.Println("Allowing the nodes to reach the API server")
+	return c.authorizeIngress(group, perm)
+}
+
+// authorizeIngress adds the permission to the security group. Each IP range is authorized
+// on its own, so that an existing range does not prevent new ones from being added.
+func (c *Client) authorizeIngress(group string, perm *ec2.IpPermission) error {
+	client, err := c.getAPIClient()
+	if err != nil {
+		return err
+	}
+	perms := []*ec2.IpPermission{perm}
+	if len(perm.IpRanges) > 1 {
+		perms = []*ec2.IpPermission{}
+		for _, r := range perm.IpRanges {
+			p := *perm
+			p.IpRanges = []*ec2.IpRange{r}
+			perms = append(perms, &p)
+		}
+	}
+	for _, p := range perms {
+		_, err := client.AuthorizeSecurityGroupIngress(&ec2.AuthorizeSecurityGroupIngressInput{
+			GroupId:       aws.String(group),
+			IpPermissions: []*ec2.IpPermission{p},
+		})
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "InvalidPermission.Duplicate" {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AllowAPIFromVPC opens the API server port of the master security group to the VPC, where
+// the traffic and the health checks of the master load balancer come from.
+func (c *Client) AllowAPIFromVPC() error {
+	group, ok := c.Config.RoleSecurityGroupIDs["master"]
+	if !ok {
+		return nil
+	}
+	client, err := c.getAPIClient()
+	if err != nil {
+		return err
+	}
+	pl, err := c.subnetPlacement()
+	if err != nil {
+		return err
+	}
+	vpcs, err := client.DescribeVpcs(&ec2.DescribeVpcsInput{VpcIds: []*string{aws.String(pl.vpc)}})
+	if err != nil {
+		return err
+	}
+	if len(vpcs.Vpcs) != 1 {
+		return fmt.Errorf("VPC %q was not found", pl.vpc)
+	}
+	return c.authorizeIngress(group, &ec2.IpPermission{
+		IpProtocol: aws.String("tcp"),
+		FromPort:   aws.Int64(apiServerPort),
+		ToPort:     aws.Int64(apiServerPort),
+		IpRanges:   []*ec2.IpRange{{CidrIp: vpcs.Vpcs[0].CidrBlock}},
+	})
+}
@@ -0,0 +1,187 @@
+package aws
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/spf13/cobra"
+)
+
+// allRoles enables spot instances for the nodes of every role
+const allRoles = "all"
+
+var spotRoles = []string{"etcd", "master", "worker", "bootstrap"}
+
+// spotCapacityErrors are the errors returned when a spot instance cannot be launched
+// at the moment, in which case an on-demand instance can be launched instead.
+var spotCapacityErrors = map[string]bool{
+	"InsufficientInstanceCapacity": true,
+	"InsufficientCapacity":         true,
+	"MaxSpotInstanceCountExceeded": true,
+	"SpotMaxPriceTooLow":           true,
+}
+
+// SpotOpts control which nodes are launched as spot instances
+type SpotOpts struct {
+	Roles    []string
+	MaxPrice string
+	Fallback bool
+}
+
+func addSpotFlags(cmd *cobra.Command, opts *SpotOpts) {
+	cmd.Flags().StringSliceVar(&opts.Roles, "spot", []string{}, "Launch the nodes as spot instances. Use --spot=worker to only launch the nodes of some roles (etcd, master, worker, bootstrap) as spot instances.")
+	cmd.Flags().Lookup("spot").NoOptDefVal = allRoles
+	cmd.Flags().StringVar(&opts.MaxPrice, "max-price", "", "Maximum hourly price in USD for spot instances. Defaults to the on-demand price.")
+	cmd.Flags().BoolVar(&opts.Fallback, "spot-fallback", false, "Launch an on-demand instance when a spot instance cannot be launched for lack of capacity or because of the maximum price.")
+}
+
+// Validate checks the roles and the maximum price
+func (opts SpotOpts) Validate() error {
+	for _, r := range opts.Roles {
+		if r == allRoles {
+			continue
+		}
+		valid := false
+		for _, s := range spotRoles {
+			valid = valid || r == s
+		}
+		if !valid {
+			return fmt.Errorf("%q is not a valid role for --spot", r)
+		}
+	}
+	if opts.MaxPrice != "" {
+		if p, err := strconv.ParseFloat(opts.MaxPrice, 64); err != nil || p <= 0 {
+			return fmt.Errorf("%q is not a valid price for --max-price", opts.MaxPrice)
+		}
+		if len(opts.Roles) == 0 {
+			return errors.New("--max-price can only be used with --spot")
+		}
+	}
+	if opts.Fallback && len(opts.Roles) == 0 {
+		return errors.New("--spot-fallback can only be used with --spot")
+	}
+	return nil
+}
+
+// enabled returns whether the nodes of the role are launched as spot instances
+func (opts SpotOpts) enabled(role string) bool {
+	for _, r := range opts.Roles {
+		if r == role || r == allRoles {
+			return true
+		}
+	}
+	return false
+}
+
+// marketOptions returns the options that launch a one-time spot instance, which is
+// terminated when interrupted.
+func (opts SpotOpts) marketOptions() *ec2.InstanceMarketOptionsRequest {
+	spot := &ec2.SpotMarketOptions{
+		SpotInstanceType:             aws.String(ec2.SpotInstanceTypeOneTime),
+		InstanceInterruptionBehavior: aws.String(ec2.InstanceInterruptionBehaviorTerminate),
+	}
+	if opts.MaxPrice != "" {
+		spot.MaxPrice = aws.String(opts.MaxPrice)
+	}
+	return &ec2.InstanceMarketOptionsRequest{
+		MarketType:  aws.String(ec2.MarketTypeSpot),
+		SpotOptions: spot,
+	}
+}
+
+// runInstances launches the instances, as spot instances if enabled for the role. The spot
+// requests get the tags of the instances.
+func (c Client) runInstances(req *ec2.RunInstancesInput, role string) (*ec2.Reservation, error) {
+	api, err := c.getAPIClient()
+	if err != nil {
+		return nil, err
+	}
+	// A new instance profile takes a few seconds to be known to EC2
+	run := func() (*ec2.Reservation, error) {
+		for attempt := uint(0); ; attempt++ {
+			res, err := api.RunInstances(req)
+			if !isInstanceProfileNotReady(err) || attempt == 5 {
+				return res, err
+			}
+			time.Sleep((1 << attempt) * time.Second)
+		}
+	}
+	spot := c.Config.Spot
+	if !spot.enabled(role) {
+		return run()
+	}
+
+	tagSpecs := req.TagSpecifications
+	req.InstanceMarketOptions = spot.marketOptions()
+	for _, t := range tagSpecs {
+		if aws.StringValue(t.ResourceType) == ec2.ResourceTypeInstance {
+			req.TagSpecifications = append(req.TagSpecifications, &ec2.TagSpecification{
+				ResourceType: aws.String(ec2.ResourceTypeSpotInstancesRequest),
+				Tags:         t.Tags,
+			})
+		}
+	}
+	res, err := run()
+	if aerr, ok := err.(awserr.Error); ok && spotCapacityErrors[aerr.Code()] && spot.Fallback {
+		fmt.Printf("\nCould not launch a spot instance (%s), launching an on-demand instance instead\n", aerr.Code())
+		req.InstanceMarketOptions = nil
+		req.TagSpecifications = tagSpecs
+		return run()
+	}
+	return res, err
+}
+
+// CancelSpotRequests cancels the spot instance requests of the instances that were created
+// by this tool from this machine. The requests are found through the instances, so that
+// delete-all does not need access to the spot requests when no spot instance was launched.
+func (c Client) CancelSpotRequests() error {
+	api, err := c.getAPIClient()
+	if err != nil {
+		return err
+	}
+	thisHost, _ := os.Hostname()
+	filters := []*ec2.Filter{
+		&ec2.Filter{
+			Name:   aws.String("instance-state-name"),
+			Values: aws.StringSlice([]string{"pending", "running", "stopping", "stopped"}),
+		},
+		&ec2.Filter{
+			Name:   aws.String("instance-lifecycle"),
+			Values: []*string{aws.String("spot")},
+		},
+		&ec2.Filter{
+			Name:   aws.String("tag:ProvisionedBy"),
+			Values: []*string{aws.String("Kismatic")},
+		},
+		&ec2.Filter{
+			Name:   aws.String("tag:CreatedBy"),
+			Values: []*string{aws.String(thisHost)},
+		},
+	}
+	ids := []*string{}
+	err = api.DescribeInstancesPages(&ec2.DescribeInstancesInput{Filters: filters}, func(page *ec2.DescribeInstancesOutput, last bool) bool {
+		for _, r := range page.Reservations {
+			for _, instance := range r.Instances {
+				if instance.SpotInstanceRequestId != nil {
+					ids = append(ids, instance.SpotInstanceRequestId)
+				}
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	fmt.Printf("Cancelling spot instance requests %v\n", aws.StringValueSlice(ids))
+	_, err = api.CancelSpotInstanceRequests(&ec2.CancelSpotInstanceRequestsInput{SpotInstanceRequestIds: ids})
+	return err
+}
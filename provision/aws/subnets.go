@@ -0,0 +1,335 @@
+package aws
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/apprenda/kismatic-provision/provision/plan"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/spf13/cobra"
+)
+
+// maxSubnetZones is the number of availability zones force provisioning spreads the cluster across
+const maxSubnetZones = 3
+
+// NetworkOpts control the VPC and subnets created when force provisioning
+type NetworkOpts struct {
+	VPCCIDR     string
+	SubnetCIDRs []string
+}
+
+func addNetworkCIDRFlags(cmd *cobra.Command, opts *NetworkOpts) {
+	cmd.Flags().StringVar(&opts.VPCCIDR, "vpc-cidr", "10.0.0.0/16", "CIDR of the VPC created when force provisioning. Must not overlap the pod and service networks of the plan.")
+	cmd.Flags().StringSliceVar(&opts.SubnetCIDRs, "subnet-cidr", []string{}, "CIDRs of the subnets created when force provisioning, spread across availability zones. Defaults to a /24 of --vpc-cidr in each of the first three zones. Can be repeated.")
+}
+
+// Validate parses the CIDRs, and makes sure they fit in each other and do not
+// overlap the pod and service networks of the plan.
+func (opts NetworkOpts) Validate() error {
+	_, vpc, err := net.ParseCIDR(opts.VPCCIDR)
+	if err != nil {
+		return fmt.Errorf("%q is not a valid CIDR for --vpc-cidr", opts.VPCCIDR)
+	}
+	if ones, _ := vpc.Mask.Size(); ones < 16 || ones > 28 {
+		return fmt.Errorf("--vpc-cidr %s must be between a /16 and a /28", opts.VPCCIDR)
+	}
+	if err := checkPlanOverlap("--vpc-cidr", vpc); err != nil {
+		return err
+	}
+	if len(opts.SubnetCIDRs) == 0 {
+		if _, err := subnetSize(vpc); err != nil {
+			return err
+		}
+	}
+	subnets := []*net.IPNet{}
+	for _, c := range opts.SubnetCIDRs {
+		_, sn, err := net.ParseCIDR(c)
+		if err != nil {
+			return fmt.Errorf("%q is not a valid CIDR for --subnet-cidr", c)
+		}
+		vpcOnes, _ := vpc.Mask.Size()
+		if ones, _ := sn.Mask.Size(); !vpc.Contains(sn.IP) || ones < vpcOnes || ones > 28 {
+			return fmt.Errorf("--subnet-cidr %s must be a /28 or larger within --vpc-cidr %s", c, opts.VPCCIDR)
+		}
+		for _, other := range subnets {
+			if overlaps(sn, other) {
+				return fmt.Errorf("--subnet-cidr %s overlaps %s", c, other)
+			}
+		}
+		subnets = append(subnets, sn)
+	}
+	return nil
+}
+
+// checkPlanOverlap returns an error if the network overlaps the pod or service network of the plan
+func checkPlanOverlap(name string, network *net.IPNet) error {
+	for _, n := range []struct{ name, cidr string }{{"pod", plan.PodCIDR}, {"service", plan.ServiceCIDR}} {
+		_, planNet, _ := net.ParseCIDR(n.cidr)
+		if overlaps(network, planNet) {
+			return fmt.Errorf("%s %s overlaps the %s network %s of the plan", name, network, n.name, n.cidr)
+		}
+	}
+	return nil
+}
+
+func overlaps(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+// subnetSize returns the prefix length of the subnets carved out of the VPC. A /24 is
+// used when the VPC is large enough, otherwise the VPC is split in four.
+func subnetSize(vpc *net.IPNet) (int, error) {
+	ones, _ := vpc.Mask.Size()
+	if ones <= 22 {
+		return 24, nil
+	}
+	if ones+2 > 28 {
+		return 0, fmt.Errorf("VPC %s is too small to be split in subnets, use --subnet-cidr", vpc)
+	}
+	return ones + 2, nil
+}
+
+// nextFreeSubnet returns the first subnet of the VPC that does not overlap the existing subnets
+func nextFreeSubnet(vpc *net.IPNet, existing []*net.IPNet) (string, error) {
+	size, err := subnetSize(vpc)
+	if err != nil {
+		return "", err
+	}
+	ones, _ := vpc.Mask.Size()
+	base := binary.BigEndian.Uint32(vpc.IP.To4())
+	for k := uint32(0); k < 1<<uint(size-ones); k++ {
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, base+k<<uint(32-size))
+		candidate := &net.IPNet{IP: ip, Mask: net.CIDRMask(size, 32)}
+		free := true
+		for _, e := range existing {
+			if overlaps(candidate, e) {
+				free = false
+				break
+			}
+		}
+		if free {
+			return candidate.String(), nil
+		}
+	}
+	return "", fmt.Errorf("VPC %s has no room left for a subnet", vpc)
+}
+
+// subnetsFromEnv splits the comma separated list of subnets of AWS_SUBNET_ID
+func subnetsFromEnv(value string) []string {
+	subnets := []string{}
+	for _, s := range strings.Split(value, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			subnets = append(subnets, s)
+		}
+	}
+	return subnets
+}
+
+// placement spreads the nodes across the availability zones of the subnets
+type placement struct {
+	vpc     string
+	zones   []string
+	subnets map[string][]string
+}
+
+// subnet returns the subnet of the i-th node of a role. Consecutive nodes are placed
+// in different availability zones, and in different subnets of the same zone.
+func (p placement) subnet(i int) string {
+	zone := p.zones[i%len(p.zones)]
+	subnets := p.subnets[zone]
+	return subnets[(i/len(p.zones))%len(subnets)]
+}
+
+// zoneSubnets returns the first subnet of each availability zone
+func (p placement) zoneSubnets() []string {
+	subnets := []string{}
+	for _, z := range p.zones {
+		subnets = append(subnets, p.subnets[z][0])
+	}
+	return subnets
+}
+
+// subnetPlacement looks up the availability zones of the configured subnets, which
+// must all be in the same VPC.
+func (c *Client) subnetPlacement() (*placement, error) {
+	if len(c.Config.SubnetIDs) == 0 {
+		return nil, errors.New("no subnet was configured")
+	}
+	api, err := c.getAPIClient()
+	if err != nil {
+		return nil, err
+	}
+	res, err := api.DescribeSubnets(&ec2.DescribeSubnetsInput{
+		SubnetIds: aws.StringSlice(c.Config.SubnetIDs),
+	})
+	if err != nil {
+		return nil, err
+	}
+	byID := map[string]*ec2.Subnet{}
+	for _, s := range res.Subnets {
+		byID[*s.SubnetId] = s
+	}
+
+	p := &placement{subnets: map[string][]string{}}
+	for _, id := range c.Config.SubnetIDs {
+		s, ok := byID[id]
+		if !ok {
+			return nil, fmt.Errorf("subnet %q was not found", id)
+		}
+		if _, network, err := net.ParseCIDR(aws.StringValue(s.CidrBlock)); err == nil {
+			if err := checkPlanOverlap("subnet "+id, network); err != nil {
+				return nil, err
+			}
+		}
+		if p.vpc == "" {
+			p.vpc = *s.VpcId
+		} else if p.vpc != *s.VpcId {
+			return nil, fmt.Errorf("subnet %q is not in VPC %q like the other subnets", id, p.vpc)
+		}
+		zone := *s.AvailabilityZone
+		if _, ok := p.subnets[zone]; !ok {
+			p.zones = append(p.zones, zone)
+		}
+		p.subnets[zone] = append(p.subnets[zone], id)
+	}
+	return p, nil
+}
+
+// checkSubnetVPC returns an error if the subnet is not in the VPC
+func (c *Client) checkSubnetVPC(id, vpc string) error {
+	api, err := c.getAPIClient()
+	if err != nil {
+		return err
+	}
+	res, err := api.DescribeSubnets(&ec2.DescribeSubnetsInput{SubnetIds: []*string{aws.String(id)}})
+	if err != nil {
+		return err
+	}
+	if len(res.Subnets) == 0 {
+		return fmt.Errorf("subnet %q was not found", id)
+	}
+	if *res.Subnets[0].VpcId != vpc {
+		return fmt.Errorf("subnet %q is not in VPC %q like the other subnets", id, vpc)
+	}
+	return nil
+}
+
+// MaybeProvisionSubnets makes sure the VPC has the subnets of the options, creating the
+// missing ones across the availability zones of the region. Without subnet CIDRs, a subnet
+// is made sure to exist in each of the first availability zones. Returns the subnets.
+func (c *Client) MaybeProvisionSubnets(vpc string, opts NetworkOpts) ([]string, error) {
+	client, err := c.getAPIClient()
+	if err != nil {
+		return nil, err
+	}
+	_, vpcNet, err := net.ParseCIDR(opts.VPCCIDR)
+	if err != nil {
+		return nil, err
+	}
+	zones, err := client.DescribeAvailabilityZones(&ec2.DescribeAvailabilityZonesInput{
+		Filters: []*ec2.Filter{
+			&ec2.Filter{
+				Name:   aws.String("state"),
+				Values: []*string{aws.String("available")},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(zones.AvailabilityZones) == 0 {
+		return nil, errors.New("no availability zone is available in the region")
+	}
+	q := &ec2.DescribeSubnetsInput{
+		Filters: []*ec2.Filter{
+			&ec2.Filter{
+				Name:   aws.String("vpc-id"),
+				Values: []*string{aws.String(vpc)},
+			},
+		},
+	}
+	a, err := client.DescribeSubnets(q)
+	if err != nil {
+		return nil, err
+	}
+	existing := []*net.IPNet{}
+	byCIDR := map[string]string{}
+	byZone := map[string]string{}
+	for _, s := range a.Subnets {
+		if _, n, err := net.ParseCIDR(*s.CidrBlock); err == nil {
+			existing = append(existing, n)
+		}
+		byCIDR[*s.CidrBlock] = *s.SubnetId
+		if _, ok := byZone[*s.AvailabilityZone]; !ok {
+			byZone[*s.AvailabilityZone] = *s.SubnetId
+		}
+	}
+
+	subnets := []string{}
+	if len(opts.SubnetCIDRs) > 0 {
+		for i, cidr := range opts.SubnetCIDRs {
+			if id, ok := byCIDR[cidr]; ok {
+				fmt.Printf("Found Subnet %v with CIDR %v\n", id, cidr)
+				subnets = append(subnets, id)
+				continue
+			}
+			id, err := c.createSubnet(vpc, cidr, *zones.AvailabilityZones[i%len(zones.AvailabilityZones)].ZoneName)
+			if err != nil {
+				return nil, err
+			}
+			subnets = append(subnets, id)
+		}
+		return subnets, nil
+	}
+
+	for _, z := range zones.AvailabilityZones {
+		if len(subnets) == maxSubnetZones {
+			break
+		}
+		if id, ok := byZone[*z.ZoneName]; ok {
+			fmt.Printf("Found Subnet %v in %v\n", id, *z.ZoneName)
+			subnets = append(subnets, id)
+			continue
+		}
+
+		cidr, err := nextFreeSubnet(vpcNet, existing)
+		if err != nil {
+			return nil, err
+		}
+		_, n, _ := net.ParseCIDR(cidr)
+		existing = append(existing, n)
+
+		id, err := c.createSubnet(vpc, cidr, *z.ZoneName)
+		if err != nil {
+			return nil, err
+		}
+		subnets = append(subnets, id)
+	}
+	return subnets, nil
+}
+
+func (c *Client) createSubnet(vpc, cidr, zone string) (string, error) {
+	client, err := c.getAPIClient()
+	if err != nil {
+		return "", err
+	}
+	fmt.Printf("Creating new Subnet %v in %v\n", cidr, zone)
+	a, err := client.CreateSubnet(&ec2.CreateSubnetInput{
+		AvailabilityZone: aws.String(zone),
+		CidrBlock:        aws.String(cidr),
+		VpcId:            aws.String(vpc),
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := c.tagResourceProvisionedBy(a.Subnet.SubnetId); err != nil {
+		fmt.Println("Error tagging new Subnet")
+	}
+	c.TagResourceName(a.Subnet.SubnetId, "Kismatic Subnet "+zone)
+	return *a.Subnet.SubnetId, nil
+}
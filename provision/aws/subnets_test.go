@@ -0,0 +1,141 @@
+package aws
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func mustParseCIDR(t *testing.T, s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		t.Fatalf("error parsing %q: %v", s, err)
+	}
+	return n
+}
+
+func TestSubnetSize(t *testing.T) {
+	tests := []struct {
+		vpc   string
+		size  int
+		valid bool
+	}{
+		{"10.0.0.0/16", 24, true},
+		{"10.0.0.0/22", 24, true},
+		{"10.0.0.0/23", 25, true},
+		{"10.0.0.0/26", 28, true},
+		{"10.0.0.0/27", 0, false},
+		{"10.0.0.0/28", 0, false},
+	}
+	for _, test := range tests {
+		size, err := subnetSize(mustParseCIDR(t, test.vpc))
+		if !test.valid {
+			if err == nil {
+				t.Errorf("subnetSize(%s): expected an error, got %d", test.vpc, size)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("subnetSize(%s): unexpected error: %v", test.vpc, err)
+			continue
+		}
+		if size != test.size {
+			t.Errorf("subnetSize(%s) = %d, expected %d", test.vpc, size, test.size)
+		}
+	}
+}
+
+func TestNextFreeSubnet(t *testing.T) {
+	tests := []struct {
+		vpc      string
+		existing []string
+		subnet   string
+		valid    bool
+	}{
+		{"10.0.0.0/16", nil, "10.0.0.0/24", true},
+		{"10.0.0.0/16", []string{"10.0.0.0/24"}, "10.0.1.0/24", true},
+		{"10.0.0.0/16", []string{"10.0.0.0/24", "10.0.2.0/24"}, "10.0.1.0/24", true},
+		// An existing subnet of another size collides with the candidates it covers
+		{"10.0.0.0/16", []string{"10.0.0.0/23"}, "10.0.2.0/24", true},
+		{"10.0.0.0/16", []string{"10.0.0.128/25"}, "10.0.1.0/24", true},
+		{"10.0.0.0/23", nil, "10.0.0.0/25", true},
+		{"10.0.0.0/23", []string{"10.0.0.0/25", "10.0.0.128/25"}, "10.0.1.0/25", true},
+		{"10.0.0.0/26", []string{"10.0.0.0/28", "10.0.0.16/28", "10.0.0.32/28", "10.0.0.48/28"}, "", false},
+		{"10.0.0.0/27", nil, "", false},
+	}
+	for _, test := range tests {
+		existing := []*net.IPNet{}
+		for _, e := range test.existing {
+			existing = append(existing, mustParseCIDR(t, e))
+		}
+		subnet, err := nextFreeSubnet(mustParseCIDR(t, test.vpc), existing)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("nextFreeSubnet(%s, %v): expected an error, got %s", test.vpc, test.existing, subnet)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("nextFreeSubnet(%s, %v): unexpected error: %v", test.vpc, test.existing, err)
+			continue
+		}
+		if subnet != test.subnet {
+			t.Errorf("nextFreeSubnet(%s, %v) = %s, expected %s", test.vpc, test.existing, subnet, test.subnet)
+		}
+	}
+}
+
+func TestNetworkOptsValidate(t *testing.T) {
+	tests := []struct {
+		opts NetworkOpts
+		err  string
+	}{
+		{opts: NetworkOpts{VPCCIDR: "10.0.0.0/16"}},
+		{opts: NetworkOpts{VPCCIDR: "10.0.0.0/23"}},
+		{opts: NetworkOpts{VPCCIDR: "10.0.0.0/27"}, err: "too small"},
+		{opts: NetworkOpts{VPCCIDR: "10.0.0.0/27", SubnetCIDRs: []string{"10.0.0.0/28", "10.0.0.16/28"}}},
+		{opts: NetworkOpts{VPCCIDR: "10.0.0.0/16", SubnetCIDRs: []string{"10.0.0.0/24", "10.0.1.0/24"}}},
+		{opts: NetworkOpts{VPCCIDR: "10.0.0.0/16", SubnetCIDRs: []string{"10.0.0.0/24", "10.0.0.128/25"}}, err: "overlaps 10.0.0.0/24"},
+		{opts: NetworkOpts{VPCCIDR: "10.0.0.0/16", SubnetCIDRs: []string{"10.1.0.0/24"}}, err: "within --vpc-cidr"},
+		{opts: NetworkOpts{VPCCIDR: "10.0.0.0/16", SubnetCIDRs: []string{"10.0.0.0/29"}}, err: "within --vpc-cidr"},
+		{opts: NetworkOpts{VPCCIDR: "10.0.0.0/16", SubnetCIDRs: []string{"10.0.0.0"}}, err: "not a valid CIDR"},
+		{opts: NetworkOpts{VPCCIDR: "10.0.0.0"}, err: "not a valid CIDR"},
+		{opts: NetworkOpts{VPCCIDR: "10.0.0.0/8"}, err: "between a /16 and a /28"},
+		{opts: NetworkOpts{VPCCIDR: "172.16.0.0/16"}, err: "pod network"},
+		{opts: NetworkOpts{VPCCIDR: "172.16.128.0/20"}, err: "pod network"},
+		{opts: NetworkOpts{VPCCIDR: "172.20.0.0/16"}, err: "service network"},
+		{opts: NetworkOpts{VPCCIDR: "172.20.0.0/24"}, err: "service network"},
+		{opts: NetworkOpts{VPCCIDR: "172.17.0.0/16"}},
+	}
+	for _, test := range tests {
+		err := test.opts.Validate()
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("Validate(%v): unexpected error: %v", test.opts, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("Validate(%v): expected an error containing %q, got %v", test.opts, test.err, err)
+		}
+	}
+}
+
+func TestCheckPlanOverlap(t *testing.T) {
+	tests := []struct {
+		network string
+		overlap bool
+	}{
+		{"10.0.0.0/16", false},
+		{"172.16.0.0/24", true},
+		{"172.0.0.0/8", true},
+		{"172.20.255.0/24", true},
+		{"172.21.0.0/16", false},
+	}
+	for _, test := range tests {
+		err := checkPlanOverlap("subnet", mustParseCIDR(t, test.network))
+		if (err != nil) != test.overlap {
+			t.Errorf("checkPlanOverlap(%s) = %v, expected overlap: %v", test.network, err, test.overlap)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package aws
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/spf13/cobra"
+)
+
+// dockerDevice is the device of the docker volume on every node, so that it can be used as the
+// direct-lvm block device of the plan. Volumes attached as /dev/sdX on the Xen instance types
+// of the blueprints show up as /dev/xvdX.
+const dockerDevice = "/dev/xvdf"
+
+// dataDevices are the devices of the other volumes, in the order the volumes are given
+var dataDevices = []string{"/dev/xvdg", "/dev/xvdh", "/dev/xvdi", "/dev/xvdj", "/dev/xvdk", "/dev/xvdl", "/dev/xvdm", "/dev/xvdn"}
+
+var volumeTypes = map[string]bool{"gp2": true, "gp3": true, "io1": true, "st1": true, "sc1": true, "standard": true}
+
+// VolumeSpec is an EBS volume attached to the nodes of a role, in addition to the root volume
+type VolumeSpec struct {
+	Role      string
+	SizeGB    int64
+	Type      string
+	IOPS      int64
+	Encrypted bool
+	KMSKeyID  string
+	Docker    bool
+}
+
+// VolumeOpts are the extra volumes, as given on the command line
+type VolumeOpts struct {
+	Volumes []string
+}
+
+func addVolumeFlags(cmd *cobra.Command, opts *VolumeOpts) {
+	cmd.Flags().StringArrayVar(&opts.Volumes, "volume", []string{}, "Extra EBS volume for the nodes of a role, as ROLE,size=GB[,type=gp2|gp3|io1|st1|sc1][,iops=N][,encrypted][,kms-key=KEY][,docker]. ROLE is etcd, master, worker or all. The docker volume is used by docker in direct-lvm mode. Can be repeated.")
+}
+
+// Parse validates the volumes and returns their specs
+func (opts VolumeOpts) Parse() ([]VolumeSpec, error) {
+	specs := []VolumeSpec{}
+	for _, v := range opts.Volumes {
+		parts := strings.Split(v, ",")
+		roles := []string{parts[0]}
+		switch parts[0] {
+		case "etcd", "master", "worker":
+		case allRoles:
+			roles = []string{"etcd", "master", "worker"}
+		default:
+			return nil, fmt.Errorf("--volume %q: %q is not a valid role", v, parts[0])
+		}
+		spec := VolumeSpec{Type: "gp2"}
+		for _, p := range parts[1:] {
+			kv := strings.SplitN(p, "=", 2)
+			var err error
+			switch {
+			case kv[0] == "size" && len(kv) == 2:
+				spec.SizeGB, err = strconv.ParseInt(kv[1], 10, 64)
+			case kv[0] == "type" && len(kv) == 2:
+				spec.Type = kv[1]
+			case kv[0] == "iops" && len(kv) == 2:
+				spec.IOPS, err = strconv.ParseInt(kv[1], 10, 64)
+			case kv[0] == "kms-key" && len(kv) == 2:
+				spec.KMSKeyID = kv[1]
+				spec.Encrypted = true
+			case p == "encrypted":
+				spec.Encrypted = true
+			case p == "docker":
+				spec.Docker = true
+			default:
+				return nil, fmt.Errorf("--volume %q: %q is not a valid option", v, p)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("--volume %q: %q is not a valid number", v, p)
+			}
+		}
+		if spec.SizeGB <= 0 {
+			return nil, fmt.Errorf("--volume %q: a size in GB is required", v)
+		}
+		if !volumeTypes[spec.Type] {
+			return nil, fmt.Errorf("--volume %q: %q is not a valid volume type", v, spec.Type)
+		}
+		if spec.Type == "io1" && spec.IOPS <= 0 {
+			return nil, fmt.Errorf("--volume %q: iops is required for io1 volumes", v)
+		}
+		if spec.IOPS > 0 && spec.Type != "io1" && spec.Type != "gp3" {
+			return nil, fmt.Errorf("--volume %q: iops can only be set on io1 and gp3 volumes", v)
+		}
+		for _, r := range roles {
+			spec.Role = r
+			specs = append(specs, spec)
+		}
+	}
+
+	for _, r := range []string{"etcd", "master", "worker"} {
+		var docker, data int
+		for _, s := range specs {
+			switch {
+			case s.Role != r:
+			case s.Docker:
+				docker++
+			default:
+				data++
+			}
+		}
+		if docker > 1 {
+			return nil, fmt.Errorf("--volume: the %s nodes can only have one docker volume", r)
+		}
+		if data > len(dataDevices) {
+			return nil, fmt.Errorf("--volume: the %s nodes can have at most %d volumes besides the docker volume", r, len(dataDevices))
+		}
+	}
+	return specs, nil
+}
+
+// ParseMinikube validates the volumes of a minikube, whose single node is a worker, and
+// returns their specs. Only the volumes of the worker nodes or of all the nodes are accepted.
+func (opts VolumeOpts) ParseMinikube() ([]VolumeSpec, error) {
+	for _, v := range opts.Volumes {
+		if role := strings.Split(v, ",")[0]; role != "worker" && role != allRoles {
+			return nil, fmt.Errorf("--volume %q: the minikube node is a worker, use worker or all instead of %q", v, role)
+		}
+	}
+	specs, err := opts.Parse()
+	if err != nil {
+		return nil, err
+	}
+	worker := []VolumeSpec{}
+	for _, s := range specs {
+		if s.Role == "worker" {
+			worker = append(worker, s)
+		}
+	}
+	return worker, nil
+}
+
+// dockerBlockDevice returns the device of the docker volume, or an empty string when there is no
+// docker volume. The nodes of each of the roles must have a docker volume if any node has one,
+// as the plan uses the same device on all the nodes.
+func dockerBlockDevice(specs []VolumeSpec, roles ...string) (string, error) {
+	hasDocker := map[string]bool{}
+	for _, s := range specs {
+		if s.Docker {
+			hasDocker[s.Role] = true
+		}
+	}
+	if len(hasDocker) == 0 {
+		return "", nil
+	}
+	for _, r := range roles {
+		if !hasDocker[r] {
+			return "", fmt.Errorf("--volume: the %s nodes need a docker volume too, as docker uses the same device on all the nodes", r)
+		}
+	}
+	return dockerDevice, nil
+}
+
+// blockDeviceMappings returns the mappings of the extra volumes of the role
+func (c ClientConfig) blockDeviceMappings(role string) []*ec2.BlockDeviceMapping {
+	mappings := []*ec2.BlockDeviceMapping{}
+	next := 0
+	for _, s := range c.Volumes {
+		if s.Role != role {
+			continue
+		}
+		device := dockerDevice
+		if !s.Docker {
+			device = dataDevices[next]
+			next++
+		}
+		ebs := &ec2.EbsBlockDevice{
+			DeleteOnTermination: aws.Bool(true),
+			VolumeSize:          aws.Int64(s.SizeGB),
+			VolumeType:          aws.String(s.Type),
+			Encrypted:           aws.Bool(s.Encrypted),
+		}
+		if s.IOPS > 0 {
+			ebs.Iops = aws.Int64(s.IOPS)
+		}
+		if s.KMSKeyID != "" {
+			ebs.KmsKeyId = aws.String(s.KMSKeyID)
+		}
+		mappings = append(mappings, &ec2.BlockDeviceMapping{DeviceName: aws.String(device), Ebs: ebs})
+	}
+	return mappings
+}
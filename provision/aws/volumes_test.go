@@ -0,0 +1,99 @@
+package aws
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestVolumeOptsParse(t *testing.T) {
+	tests := []struct {
+		volumes []string
+		specs   []VolumeSpec
+		err     string
+	}{
+		{
+			volumes: []string{},
+			specs:   []VolumeSpec{},
+		},
+		{
+			volumes: []string{"worker,size=100,type=gp3,iops=4000,docker"},
+			specs:   []VolumeSpec{{Role: "worker", SizeGB: 100, Type: "gp3", IOPS: 4000, Docker: true}},
+		},
+		{
+			volumes: []string{"master,size=20,kms-key=alias/kismatic"},
+			specs:   []VolumeSpec{{Role: "master", SizeGB: 20, Type: "gp2", Encrypted: true, KMSKeyID: "alias/kismatic"}},
+		},
+		{
+			volumes: []string{"all,size=10,encrypted"},
+			specs: []VolumeSpec{
+				{Role: "etcd", SizeGB: 10, Type: "gp2", Encrypted: true},
+				{Role: "master", SizeGB: 10, Type: "gp2", Encrypted: true},
+				{Role: "worker", SizeGB: 10, Type: "gp2", Encrypted: true},
+			},
+		},
+		{volumes: []string{"ingress,size=10"}, err: "not a valid role"},
+		{volumes: []string{"worker"}, err: "a size in GB is required"},
+		{volumes: []string{"worker,size=ten"}, err: "not a valid number"},
+		{volumes: []string{"worker,size=10,type=gp1"}, err: "not a valid volume type"},
+		{volumes: []string{"worker,size=10,fast"}, err: "not a valid option"},
+		{volumes: []string{"worker,size=10,type=io1"}, err: "iops is required"},
+		{volumes: []string{"worker,size=10,iops=100"}, err: "iops can only be set"},
+		{volumes: []string{"worker,size=10,docker", "all,size=10,docker"}, err: "only have one docker volume"},
+		{
+			volumes: []string{"etcd,size=1", "etcd,size=1", "etcd,size=1", "etcd,size=1", "etcd,size=1", "etcd,size=1", "etcd,size=1", "etcd,size=1", "etcd,size=1"},
+			err:     "at most 8 volumes",
+		},
+	}
+	for i, test := range tests {
+		specs, err := VolumeOpts{Volumes: test.volumes}.Parse()
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("test %d: expected an error containing %q, got %v", i, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d: unexpected error: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(specs, test.specs) {
+			t.Errorf("test %d: expected %+v, got %+v", i, test.specs, specs)
+		}
+	}
+}
+
+func TestVolumeOptsParseMinikube(t *testing.T) {
+	tests := []struct {
+		volumes []string
+		specs   []VolumeSpec
+		err     string
+	}{
+		{
+			volumes: []string{"worker,size=100,docker", "all,size=10"},
+			specs: []VolumeSpec{
+				{Role: "worker", SizeGB: 100, Type: "gp2", Docker: true},
+				{Role: "worker", SizeGB: 10, Type: "gp2"},
+			},
+		},
+		{volumes: []string{"etcd,size=10"}, err: "the minikube node is a worker"},
+		{volumes: []string{"worker,size=10", "master,size=20,docker"}, err: "the minikube node is a worker"},
+		{volumes: []string{"worker,size=0"}, err: "a size in GB is required"},
+	}
+	for i, test := range tests {
+		specs, err := VolumeOpts{Volumes: test.volumes}.ParseMinikube()
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("test %d: expected an error containing %q, got %v", i, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d: unexpected error: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(specs, test.specs) {
+			t.Errorf("test %d: expected %+v, got %+v", i, test.specs, specs)
+		}
+	}
+}
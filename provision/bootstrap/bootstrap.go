@@ -0,0 +1,210 @@
+package bootstrap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"text/template"
+	"time"
+
+	"github.com/apprenda/kismatic-provision/provision/plan"
+	"github.com/apprenda/kismatic-provision/provision/remote"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// DefaultInstallDir is the directory on the bootstrap node where KET is installed
+	DefaultInstallDir = "/ket"
+	// DefaultKETVersion is the version of KET that is installed on the bootstrap node
+	DefaultKETVersion = "v1.10.0"
+	// DefaultKubectlVersion is the version of kubectl that is installed on the bootstrap node
+	DefaultKubectlVersion = "v1.10.3"
+	// PlanFile is the name of the plan file on the bootstrap node
+	PlanFile = "kismatic-cluster.yaml"
+
+	timeout = 10 * time.Minute
+)
+
+// ketChecksums are the SHA-256 checksums of the amd64 KET release tarballs, by version and
+// OS. The checksums of DefaultKETVersion have to be pinned here whenever it changes.
+var ketChecksums = map[string]map[string]string{}
+
+// kubectlChecksums are the SHA-256 checksums of the linux amd64 kubectl binaries, by version.
+// The checksum of DefaultKubectlVersion has to be pinned here whenever it changes.
+var kubectlChecksums = map[string]string{}
+
+var (
+	validVersion  = regexp.MustCompile(`^v[0-9]+\.[0-9]+\.[0-9]+(-[0-9A-Za-z.]+)?$`)
+	validChecksum = regexp.MustCompile(`^[0-9a-f]{64}$`)
+	validDir      = regexp.MustCompile(`^/[0-9A-Za-z._/-]*$`)
+)
+
+// PinnedKETChecksum returns the pinned checksum of the KET release tarball for the OS, or
+// an empty string if there is none.
+func PinnedKETChecksum(version, goos string) string {
+	return ketChecksums[version][goos]
+}
+
+// Opts are the options for preparing a bootstrap node
+type Opts struct {
+	InstallDir         string
+	KETVersion         string
+	KETChecksum        string
+	KubectlVersion     string
+	KubectlChecksum    string
+	InsecureSkipVerify bool
+}
+
+// AddFlags adds the flags that control what is installed on the bootstrap node
+func AddFlags(cmd *cobra.Command, opts *Opts) {
+	cmd.Flags().StringVar(&opts.KETVersion, "ket-version", DefaultKETVersion, "Version of KET to install on the bootstrap node.")
+	cmd.Flags().StringVar(&opts.KETChecksum, "ket-sha256", "", "SHA-256 checksum of the linux KET release tarball. Required with --ket-version, unless the checksum of the version is pinned.")
+	cmd.Flags().StringVar(&opts.KubectlVersion, "kubectl-version", DefaultKubectlVersion, "Version of kubectl to install on the bootstrap node.")
+	cmd.Flags().StringVar(&opts.KubectlChecksum, "kubectl-sha256", "", "SHA-256 checksum of the linux kubectl binary. Required with --kubectl-version, unless the checksum of the version is pinned.")
+	cmd.Flags().BoolVar(&opts.InsecureSkipVerify, "insecure-skip-verify", false, "Install KET and kubectl without verifying their checksums when none is known.")
+}
+
+// checksums returns the versions and checksums to install, with the defaults filled in
+func (opts Opts) checksums() Opts {
+	if opts.KETVersion == "" {
+		opts.KETVersion = DefaultKETVersion
+	}
+	if opts.KubectlVersion == "" {
+		opts.KubectlVersion = DefaultKubectlVersion
+	}
+	if opts.KETChecksum == "" {
+		opts.KETChecksum = PinnedKETChecksum(opts.KETVersion, "linux")
+	}
+	if opts.KubectlChecksum == "" {
+		opts.KubectlChecksum = kubectlChecksums[opts.KubectlVersion]
+	}
+	return opts
+}
+
+// Validate checks the versions and checksums, which must be known for the downloads to be
+// verified unless InsecureSkipVerify is set.
+func (opts Opts) Validate() error {
+	opts = opts.checksums()
+	if !validDir.MatchString(opts.installDir()) {
+		return fmt.Errorf("%q is not a valid install directory, it must be an absolute path without spaces or quotes", opts.installDir())
+	}
+	if !validVersion.MatchString(opts.KETVersion) {
+		return fmt.Errorf("%q is not a valid KET version", opts.KETVersion)
+	}
+	if !validVersion.MatchString(opts.KubectlVersion) {
+		return fmt.Errorf("%q is not a valid kubectl version", opts.KubectlVersion)
+	}
+	if opts.KETChecksum != "" && !validChecksum.MatchString(opts.KETChecksum) {
+		return errors.New("--ket-sha256 must be 64 lowercase hexadecimal characters")
+	}
+	if opts.KubectlChecksum != "" && !validChecksum.MatchString(opts.KubectlChecksum) {
+		return errors.New("--kubectl-sha256 must be 64 lowercase hexadecimal characters")
+	}
+	if opts.InsecureSkipVerify {
+		return nil
+	}
+	if opts.KETChecksum == "" {
+		return fmt.Errorf("no checksum is known for KET %s, use --ket-sha256 to verify the download, or --insecure-skip-verify", opts.KETVersion)
+	}
+	if opts.KubectlChecksum == "" {
+		return fmt.Errorf("no checksum is known for kubectl %s, use --kubectl-sha256 to verify the download, or --insecure-skip-verify", opts.KubectlVersion)
+	}
+	return nil
+}
+
+func (opts Opts) installDir() string {
+	if opts.InstallDir == "" {
+		return DefaultInstallDir
+	}
+	return opts.InstallDir
+}
+
+// KeyPath returns the path of the SSH private key once it has been uploaded to the
+// bootstrap node. This is the path that must be used in the plan file.
+func (opts Opts) KeyPath(sshKey string) string {
+	return path.Join(opts.installDir(), "ssh", filepath.Base(sshKey))
+}
+
+// Script returns the script that installs KET and kubectl on the bootstrap node
+func (opts Opts) Script() (string, error) {
+	if err := opts.Validate(); err != nil {
+		return "", err
+	}
+	t, err := template.New("bootstrap").Parse(script)
+	if err != nil {
+		return "", err
+	}
+	data := opts.checksums()
+	data.InstallDir = opts.installDir()
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// Prepare installs KET and kubectl on the bootstrap node, and uploads the SSH private
+// key and the plan file to it.
+func (opts Opts) Prepare(node plan.Node, sshKey string, planFile string) error {
+	s, err := opts.Script()
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile("", "ket-bootstrap")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(s); err != nil {
+		f.Close()
+		return err
+	}
+	f.Close()
+
+	fmt.Println("Installing KET and kubectl on the bootstrap node")
+	if err := remote.CopyFileToRemote(f.Name(), "/tmp/ket-bootstrap.sh", node, sshKey, timeout); err != nil {
+		return fmt.Errorf("error uploading bootstrap script: %v", err)
+	}
+	if err := remote.RunViaSSH([]string{"bash /tmp/ket-bootstrap.sh"}, []plan.Node{node}, sshKey, timeout); err != nil {
+		return fmt.Errorf("error installing KET on the bootstrap node: %v", err)
+	}
+
+	fmt.Println("Copying SSH key and plan file to the bootstrap node")
+	if err := remote.CopyFileToRemote(sshKey, opts.KeyPath(sshKey), node, sshKey, timeout); err != nil {
+		return fmt.Errorf("error uploading SSH key: %v", err)
+	}
+	if err := remote.CopyFileToRemote(planFile, path.Join(opts.installDir(), PlanFile), node, sshKey, timeout); err != nil {
+		return fmt.Errorf("error uploading plan file: %v", err)
+	}
+	cmds := []string{fmt.Sprintf("chmod 600 '%s'", opts.KeyPath(sshKey))}
+	if err := remote.RunViaSSH(cmds, []plan.Node{node}, sshKey, timeout); err != nil {
+		return fmt.Errorf("error setting SSH key permissions: %v", err)
+	}
+	return nil
+}
+
+// PrintInstructions prints out the commands to install the cluster from the bootstrap node
+func (opts Opts) PrintInstructions(node plan.Node, sshKey string) {
+	fmt.Println("To install your cluster, connect to the bootstrap node:")
+	fmt.Printf("ssh -i %s %s@%s\n", sshKey, node.SSHUser, node.PublicIPv4)
+	fmt.Println("and run:")
+	fmt.Printf("cd %s && ./kismatic install apply -f %s\n", opts.installDir(), PlanFile)
+}
+
+// Install validates the plan file and installs the cluster from the bootstrap node.
+// The output of kismatic is streamed to stdout.
+func (opts Opts) Install(node plan.Node, sshKey string) error {
+	cmd := fmt.Sprintf("cd %s && ./kismatic install validate -f %s && ./kismatic install apply -f %s", opts.installDir(), PlanFile, PlanFile)
+	fmt.Println("Installing the cluster from the bootstrap node")
+	if err := remote.Shell(node, sshKey, cmd); err != nil {
+		return fmt.Errorf("error installing the cluster from the bootstrap node: %v", err)
+	}
+	fmt.Println("Your cluster is installed. The kubeconfig file is on the bootstrap node at:")
+	fmt.Println(path.Join(opts.installDir(), "generated", "kubeconfig"))
+	return nil
+}
@@ -0,0 +1,61 @@
+package bootstrap
+
+// script installs KET and kubectl on the bootstrap node. It is run by a user
+// that must be able to sudo without a password, and can also be used as user data.
+const script = `#!/bin/bash
+set -e
+
+INSTALL_DIR='{{.InstallDir}}'
+KET_VERSION='{{.KETVersion}}'
+KET_SHA256='{{.KETChecksum}}'
+KUBECTL_VERSION='{{.KubectlVersion}}'
+KUBECTL_SHA256='{{.KubectlChecksum}}'
+
+KET_URL="https://github.com/apprenda/kismatic/releases/download/$KET_VERSION/kismatic-$KET_VERSION-linux-amd64.tar.gz"
+KUBECTL_URL="https://storage.googleapis.com/kubernetes-release/release/$KUBECTL_VERSION/bin/linux/amd64/kubectl"
+
+# verify checks the SHA-256 checksum of a download, which is only skipped when no checksum
+# is known and --insecure-skip-verify was given
+verify() {
+  if [ -n "$2" ]; then
+    echo "$2  $1" | sha256sum -c -
+{{- if .InsecureSkipVerify}}
+  else
+    echo "WARNING: $1 is not verified, as no checksum is known" >&2
+{{- else}}
+  else
+    echo "No checksum is known for $1" >&2
+    exit 1
+{{- end}}
+  fi
+}
+
+# KET requires python 2.7 on the machine it runs from
+if command -v apt-get > /dev/null; then
+  sudo apt-get update -qq
+  sudo apt-get install -qq -y curl ca-certificates python2.7
+  [ -e /usr/bin/python ] || sudo ln -s /usr/bin/python2.7 /usr/bin/python
+elif command -v dnf > /dev/null; then
+  sudo dnf install -q -y curl ca-certificates python2
+  [ -e /usr/bin/python ] || sudo ln -s /usr/bin/python2 /usr/bin/python
+elif command -v yum > /dev/null; then
+  sudo yum install -q -y curl ca-certificates python
+else
+  echo "Unsupported distribution: could not find apt-get, dnf or yum" >&2
+  exit 1
+fi
+
+sudo mkdir -p "$INSTALL_DIR/ssh"
+sudo chown -R $(id -u):$(id -g) "$INSTALL_DIR"
+cd "$INSTALL_DIR"
+
+curl -fsSL -o kismatic.tar.gz "$KET_URL"
+verify kismatic.tar.gz "$KET_SHA256"
+tar -zxf kismatic.tar.gz
+rm kismatic.tar.gz
+
+curl -fsSL -o kubectl "$KUBECTL_URL"
+verify kubectl "$KUBECTL_SHA256"
+chmod +x kubectl
+sudo mv kubectl /usr/local/bin/kubectl
+`
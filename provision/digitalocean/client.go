@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
+	"sort"
 
 	"github.com/digitalocean/godo"
 	"golang.org/x/oauth2"
@@ -79,21 +80,61 @@ func (c Client) GetDroplet(token string, dropletID int) (Droplet, error) {
 		fmt.Println("Cannot create host", errhost)
 		return drop, errhost
 	}
-	drop.ID = newDroplet.ID
-	drop.Name = newDroplet.Name
-	if newDroplet.Networks.V4 != nil {
-		for i := 0; i < len(newDroplet.Networks.V4); i++ {
-			if newDroplet.Networks.V4[i].Type == "public" {
-				drop.PublicIP = newDroplet.Networks.V4[i].IPAddress
-			}
-			if newDroplet.Networks.V4[i].Type == "private" {
-				drop.PrivateIP = newDroplet.Networks.V4[i].IPAddress
-			}
+	return toDroplet(newDroplet), nil
+}
+
+// ListDropletsByTag returns all the droplets that have the given tag, in the
+// order in which they were created.
+func (c Client) ListDropletsByTag(token string, tag string) ([]Droplet, error) {
+	client, err := c.getAPIClient(token)
+	if err != nil {
+		fmt.Println("Cannot get api object", err)
+		return nil, err
+	}
+
+	ctx := context.TODO()
+	opts := &godo.ListOptions{PerPage: 200}
+	list := []godo.Droplet{}
+	for {
+		page, resp, err := client.Droplets.ListByTag(ctx, tag, opts)
+		if err != nil {
+			return nil, err
 		}
+		list = append(list, page...)
+		if resp.Links == nil || resp.Links.IsLastPage() {
+			break
+		}
+		current, err := resp.Links.CurrentPage()
+		if err != nil {
+			return nil, err
+		}
+		opts.Page = current + 1
 	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
 
-	return drop, nil
+	drops := []Droplet{}
+	for i := range list {
+		drops = append(drops, toDroplet(&list[i]))
+	}
+	return drops, nil
+}
 
+func toDroplet(d *godo.Droplet) Droplet {
+	drop := Droplet{
+		ID:   d.ID,
+		Name: d.Name,
+	}
+	if d.Networks != nil {
+		for i := 0; i < len(d.Networks.V4); i++ {
+			if d.Networks.V4[i].Type == "public" {
+				drop.PublicIP = d.Networks.V4[i].IPAddress
+			}
+			if d.Networks.V4[i].Type == "private" {
+				drop.PrivateIP = d.Networks.V4[i].IPAddress
+			}
+		}
+	}
+	return drop
 }
 
 func (c Client) CreateNode(token string, config NodeConfig, keyconfig KeyConfig) (Droplet, error) {
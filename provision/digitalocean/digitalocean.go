@@ -7,10 +7,16 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"time"
 
 	"strings"
 
+	"github.com/apprenda/kismatic-provision/provision/bootstrap"
+	"github.com/apprenda/kismatic-provision/provision/install"
 	"github.com/apprenda/kismatic-provision/provision/plan"
+	"github.com/apprenda/kismatic-provision/provision/remote"
+	"github.com/apprenda/kismatic-provision/provision/userdata"
+	"github.com/apprenda/kismatic-provision/provision/validate"
 	"github.com/spf13/cobra"
 )
 
@@ -33,6 +39,12 @@ type DOOpts struct {
 	BootstrapNode   bool
 	RemoveKey       bool
 	BootstrapFile   string
+	Bootstrap       bootstrap.Opts
+	Hooks           remote.HookOpts
+	UserData        userdata.Opts
+	Install         install.Opts
+	Validate        validate.Opts
+	MasterLB        bool
 }
 
 func Cmd() *cobra.Command {
@@ -44,6 +56,7 @@ func Cmd() *cobra.Command {
 
 	cmd.AddCommand(DOCreateCmd())
 	cmd.AddCommand(DODeleteCmd())
+	cmd.AddCommand(DOSSHCmd())
 
 	return cmd
 }
@@ -54,10 +67,10 @@ func DOCreateCmd() *cobra.Command {
 		Use:   "create",
 		Short: "Creates infrastructure for a new cluster.",
 		Long: `Creates infrastructure for a new cluster. Optionally creates a bootstrap node to run the orchestration of Kubernetes
-cluster from. If the bootstrap node is requested, the provisioner will download kismatic executables and kubectl during the process
-of VM initialization. By default, it will place the downloaded packages in the /ket/ folder. The default location can be overwritten
-by setting an environmental variable 'DO_KET_INSTALL_DIR'. If the bootstrap node is not requested, the Kismatic and Kubectl packages
-will have to be downloaded manually. See digitalocean/scripts/bootinit.sh for details.
+cluster from. If the bootstrap node is requested, the provisioner will install the requested versions of kismatic and kubectl
+on it once it is accessible via SSH, and copy the plan file and SSH key to it. By default, it will place the downloaded packages
+in the /ket/ folder. The default location can be overwritten by setting an environmental variable 'DO_KET_INSTALL_DIR'. If the
+bootstrap node is not requested, the Kismatic and Kubectl packages will have to be downloaded manually.
 
 In addition to the commands below, the provisioner relies on some environment variables and conventions:
 Required:
@@ -66,6 +79,7 @@ Required:
 not exist, an attempt will be made to use ssh key file in the following relative location: ssh/cluster.pem file. If the file is
 not found, the program will fail.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			opts.Install.SetRelease(opts.Bootstrap)
 			return makeInfra(opts)
 		},
 	}
@@ -80,9 +94,15 @@ not found, the program will fail.`,
 	cmd.Flags().StringVarP(&opts.Region, "region", "", "tor1", "Region to deploy to")
 	cmd.Flags().StringVarP(&opts.ClusterTag, "tag", "", "apprenda", "TAG for all nodes in the cluster")
 	cmd.Flags().StringVarP(&opts.SSHUser, "sshuser", "", "root", "SSH User name")
+	cmd.Flags().BoolVar(&opts.MasterLB, "master-lb", false, "Create a load balancer in front of the master nodes, and use it as the load balancer of the plan.")
 	cmd.Flags().BoolVarP(&opts.BootstrapNode, "bootstrap", "", true, "Create a bootstrap node from which users can work with the cluster.")
 	cmd.Flags().BoolVarP(&opts.Storage, "storage-cluster", "s", false, "Create a storage cluster from all Worker nodes.")
-	cmd.Flags().StringVarP(&opts.BootstrapFile, "bootstrap-commands-file", "", "", "Relative path to the script file that will be run on the bootstrap node upon initialization. e.g.: digitalocean/scripts/bootinit.sh.")
+	cmd.Flags().StringVarP(&opts.BootstrapFile, "bootstrap-commands-file", "", "", "Path to an additional script file that will be run on the bootstrap node upon initialization.")
+	bootstrap.AddFlags(cmd, &opts.Bootstrap)
+	userdata.AddFlags(cmd, &opts.UserData)
+	remote.AddHookFlags(cmd, &opts.Hooks)
+	validate.AddFlags(cmd, &opts.Validate)
+	install.AddFlags(cmd, &opts.Install)
 
 	return cmd
 }
@@ -92,7 +112,7 @@ func DODeleteCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "delete-all",
 		Short: "Deletes all the nodes from the Digital Ocean account",
-		Long:  `Deletes all the nodes based on the tag provided and also, if requested, removes the ssh key created during the provisioning`,
+		Long:  `Deletes all the nodes based on the tag provided, along with the load balancer of the masters, and also, if requested, removes the ssh key created during the provisioning`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return deleteInfra(opts)
 		},
@@ -104,21 +124,76 @@ func DODeleteCmd() *cobra.Command {
 	return cmd
 }
 
-func deleteInfra(opts DOOpts) error {
-	opts.Token = os.Getenv("DO_API_TOKEN")
+func DOSSHCmd() *cobra.Command {
+	opts := DOOpts{}
+	var timeout time.Duration
+	cmd := &cobra.Command{
+		Use:   "ssh HOSTNAME|ROLE|ROLE[INDEX] [-- COMMAND]",
+		Short: "Connects to a node of the cluster using SSH.",
+		Long: `Connects to a node of the cluster using SSH.
+
+Nodes can be selected by name, public or private IP, or by role and index (e.g. master[0]). 
+When a role is given without an index, the command is run on all the nodes of that role in parallel.`,
+		Example: `# Open a shell on the bootstrap node
+provision do ssh bootstrap1
+
+# Check the uptime of all worker nodes
+provision do ssh worker -- uptime`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			selector, command, err := remote.SplitArgs(cmd, args)
+			if err != nil {
+				return err
+			}
+			return sshInfra(opts, selector, command, timeout)
+		},
+	}
+
+	cmd.Flags().StringVarP(&opts.ClusterTag, "tag", "", "apprenda", "TAG of the nodes in the cluster")
+	cmd.Flags().StringVarP(&opts.SSHUser, "sshuser", "", "root", "SSH User name")
+	cmd.Flags().DurationVar(&timeout, "timeout", 10*time.Minute, "Maximum time to wait for a command to complete on all nodes.")
+
+	return cmd
+}
+
+func readToken(prompt string) string {
+	token := os.Getenv("DO_API_TOKEN")
 	reader := bufio.NewReader(os.Stdin)
-	if opts.Token == "" {
-		fmt.Print("Enter Digital Ocean API Token: ")
+	if token == "" {
+		fmt.Print(prompt)
 		url, _ := reader.ReadString('\n')
-		opts.Token = strings.Trim(url, "\n")
-		opts.Token = strings.Replace(opts.Token, "\r", "", -1) //for Windows
+		token = strings.Trim(url, "\n")
+		token = strings.Replace(token, "\r", "", -1) //for Windows
 	}
+	return token
+}
+
+func deleteInfra(opts DOOpts) error {
+	opts.Token = readToken("Enter Digital Ocean API Token: ")
 
 	provisioner, _ := GetProvisioner()
 
 	return provisioner.TerminateNodes(opts)
 }
 
+func sshInfra(opts DOOpts, selector string, command []string, timeout time.Duration) error {
+	opts.Token = readToken("Enter Digital Ocean API Token: ")
+	if opts.Token == "" {
+		return fmt.Errorf("The DigitalOcean API Token is required")
+	}
+	sshPrivate, _, err := validateKeyFile(opts)
+	if err != nil {
+		return err
+	}
+
+	provisioner, _ := GetProvisioner()
+	cluster, err := provisioner.Cluster(opts)
+	if err != nil {
+		return err
+	}
+
+	return remote.SSH(cluster, selector, command, sshPrivate, timeout)
+}
+
 func validateKeyFile(opts DOOpts) (string, string, error) {
 	var filePath string
 
@@ -145,17 +220,34 @@ func validateKeyFile(opts DOOpts) (string, string, error) {
 }
 
 func makeInfra(opts DOOpts) error {
-	opts.Token = os.Getenv("DO_API_TOKEN")
-	reader := bufio.NewReader(os.Stdin)
-	if opts.Token == "" {
-		fmt.Print("Enter Digital Ocean API Token: \n")
-		url, _ := reader.ReadString('\n')
-		opts.Token = strings.Trim(url, "\n")
-		opts.Token = strings.Replace(opts.Token, "\r", "", -1) //for Windows
-	}
+	opts.Token = readToken("Enter Digital Ocean API Token: \n")
 	if opts.Token == "" {
 		return fmt.Errorf("The DigitalOcean API Token is required")
 	}
+	if _, err := opts.Hooks.Hooks(); err != nil {
+		return err
+	}
+	if err := opts.UserData.Validate(); err != nil {
+		return err
+	}
+	opts.Bootstrap.InstallDir = os.Getenv("DO_KET_INSTALL_DIR")
+	if opts.BootstrapNode {
+		if err := opts.Bootstrap.Validate(); err != nil {
+			return err
+		}
+	}
+	// The load balancer targets the masters by a tag derived from the cluster tag
+	if opts.MasterLB && opts.ClusterTag == "" {
+		return fmt.Errorf("--master-lb requires a non-empty --tag")
+	}
+	if opts.Install.Enabled && opts.NoPlan {
+		return fmt.Errorf("--install cannot be used with --noplan")
+	}
+	if !opts.BootstrapNode {
+		if err := opts.Install.Validate(); err != nil {
+			return err
+		}
+	}
 	sshPrivate, sshPublic, errkey := validateKeyFile(opts)
 	if errkey != nil {
 		return errkey
@@ -194,9 +286,30 @@ func makeInfra(opts DOOpts) error {
 		return err
 	}
 
+	if err = opts.Hooks.RunHooks(nodes.cluster(), opts.SSHPrivateKey); err != nil {
+		return err
+	}
+	if err = opts.Validate.Run(nodes.cluster(), opts.SSHPrivateKey); err != nil {
+		return err
+	}
+
+	loadBalancer := nodes.Master[0].PublicIPv4
+	var extraSANs string
+	if opts.MasterLB {
+		ip, err := provisioner.client.CreateLoadBalancer(opts.Token, lbName(opts.ClusterTag), opts.Region, masterTag(opts.ClusterTag))
+		if err != nil {
+			return err
+		}
+		loadBalancer = ip
+		extraSANs = ip
+	}
+
 	if opts.NoPlan {
 		fmt.Println("Your instances are ready.\n")
 		printNodes(&nodes)
+		if opts.MasterLB {
+			fmt.Printf("Load balancer:\n  %v\n", loadBalancer)
+		}
 		return nil
 	}
 
@@ -204,28 +317,24 @@ func makeInfra(opts DOOpts) error {
 	if opts.Storage {
 		storageNodes = nodes.Worker
 	}
-	root := os.Getenv("DO_KET_INSTALL_DIR")
-	if root == "" {
-		root = KET_INSTALL_DIR
-	}
-
 	sshKeyFile := opts.SSHPrivateKey
 	// If the user asks for a bootstrap node, the generated plan file will contain
 	// the path to the SSH key on the bootstrap node, and not on the node that is running
 	// provision.
 	if opts.BootstrapNode {
-		sshKeyFile = fmt.Sprintf("%s/ssh/%s", root, opts.SSHKeyName)
+		sshKeyFile = opts.Bootstrap.KeyPath(opts.SSHPrivateKey)
 	}
 
 	return makePlan(&plan.Plan{
-		Etcd:         nodes.Etcd,
-		Master:       nodes.Master,
-		Worker:       nodes.Worker,
-		Ingress:      []plan.Node{nodes.Worker[0]},
-		Storage:      storageNodes,
-		LoadBalancer: nodes.Master[0].PublicIPv4 + ":6443",
-		SSHKeyFile:   sshKeyFile,
-		SSHUser:      nodes.Master[0].SSHUser,
+		Etcd:                   nodes.Etcd,
+		Master:                 nodes.Master,
+		Worker:                 nodes.Worker,
+		Ingress:                []plan.Node{nodes.Worker[0]},
+		Storage:                storageNodes,
+		LoadBalancer:           loadBalancer + ":6443",
+		APIServerCertExtraSANs: extraSANs,
+		SSHKeyFile:             sshKeyFile,
+		SSHUser:                nodes.Master[0].SSHUser,
 	}, opts, nodes)
 
 }
@@ -251,24 +360,20 @@ func makePlan(pln *plan.Plan, opts DOOpts, nodes ProvisionedNodes) error {
 
 	w.Flush()
 
-	//scp plan file to bootstrap if requested
+	//install KET and push the plan file to bootstrap if requested
 	if opts.BootstrapNode {
 		boot := nodes.Boostrap[0]
-		planPath, _ := filepath.Abs(f.Name())
-		fmt.Println("Copying kismatic plan file to bootstrap node:", planPath)
-		root := os.Getenv("DO_KET_INSTALL_DIR")
-		if root == "" {
-			root = KET_INSTALL_DIR
+		if err := opts.Bootstrap.Prepare(boot, opts.SSHPrivateKey, f.Name()); err != nil {
+			return err
 		}
-		if opts.BootstrapFile == "" {
-			root = ""
+		if opts.Install.Enabled {
+			return opts.Bootstrap.Install(boot, opts.SSHPrivateKey)
 		}
-		destPath := root + "/kismatic-cluster.yaml"
-		out, scperr := scpFile(planPath, destPath, opts.SSHUser, boot.PublicIPv4, opts.SSHPrivateKey)
-		if scperr != nil {
-			return fmt.Errorf("Unable to push kismatic plan to boostrap node: %v", scperr)
-		}
-		fmt.Println("Output:", out)
+		opts.Bootstrap.PrintInstructions(boot, opts.SSHPrivateKey)
+		return nil
+	}
+	if opts.Install.Enabled {
+		return opts.Install.Run(f.Name())
 	}
 	fmt.Println("To install your cluster, run:")
 	fmt.Println("./kismatic install apply -f " + f.Name())
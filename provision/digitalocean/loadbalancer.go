@@ -0,0 +1,122 @@
+package digitalocean
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/digitalocean/godo"
+)
+
+const (
+	apiServerPort = 6443
+	lbTimeout     = 10 * time.Minute
+)
+
+// masterTag returns the tag of the master droplets, used to target them from the load balancer
+func masterTag(clusterTag string) string {
+	return clusterTag + "-master"
+}
+
+// lbName returns the name of the load balancer of the cluster
+func lbName(clusterTag string) string {
+	return clusterTag + "-api"
+}
+
+// CreateLoadBalancer creates a load balancer that forwards the API server port to the
+// droplets with the given tag, and waits for its IP to be assigned.
+func (c Client) CreateLoadBalancer(token string, name string, region string, tag string) (string, error) {
+	client, err := c.getAPIClient(token)
+	if err != nil {
+		fmt.Println("Cannot get api object", err)
+		return "", err
+	}
+	ctx := context.TODO()
+
+	req := &godo.LoadBalancerRequest{
+		Name:      name,
+		Algorithm: "round_robin",
+		Region:    region,
+		ForwardingRules: []godo.ForwardingRule{
+			{
+				EntryProtocol:  "tcp",
+				EntryPort:      apiServerPort,
+				TargetProtocol: "tcp",
+				TargetPort:     apiServerPort,
+			},
+		},
+		HealthCheck: &godo.HealthCheck{
+			Protocol:               "tcp",
+			Port:                   apiServerPort,
+			CheckIntervalSeconds:   10,
+			ResponseTimeoutSeconds: 5,
+			HealthyThreshold:       3,
+			UnhealthyThreshold:     3,
+		},
+		Tag: tag,
+	}
+	fmt.Println("Creating load balancer", name)
+	lb, _, err := client.LoadBalancers.Create(ctx, req)
+	if err != nil {
+		fmt.Println("Cannot create load balancer", err)
+		return "", err
+	}
+
+	fmt.Printf("Waiting for the load balancer IP to be assigned")
+	timeout := time.After(lbTimeout)
+	for lb.IP == "" {
+		select {
+		case <-timeout:
+			fmt.Println()
+			return "", fmt.Errorf("timed out waiting for load balancer %s", name)
+		case <-time.After(5 * time.Second):
+		}
+		fmt.Printf(".")
+		lb, _, err = client.LoadBalancers.Get(ctx, lb.ID)
+		if err != nil {
+			fmt.Println()
+			return "", err
+		}
+	}
+	fmt.Println()
+	fmt.Printf("IP assigned to load balancer %s: %s\n", name, lb.IP)
+	return lb.IP, nil
+}
+
+// DeleteLoadBalancerByName deletes the load balancers with the given name
+func (c Client) DeleteLoadBalancerByName(token string, name string) error {
+	client, err := c.getAPIClient(token)
+	if err != nil {
+		fmt.Println("Cannot get api object", err)
+		return err
+	}
+	ctx := context.TODO()
+	opts := &godo.ListOptions{PerPage: 200}
+	lbs := []godo.LoadBalancer{}
+	for {
+		page, resp, err := client.LoadBalancers.List(ctx, opts)
+		if err != nil {
+			fmt.Println("Cannot load load balancers", err)
+			return err
+		}
+		lbs = append(lbs, page...)
+		if resp.Links == nil || resp.Links.IsLastPage() {
+			break
+		}
+		current, err := resp.Links.CurrentPage()
+		if err != nil {
+			return err
+		}
+		opts.Page = current + 1
+	}
+	for _, lb := range lbs {
+		if lb.Name != name {
+			continue
+		}
+		fmt.Println("Deleting load balancer", name)
+		if _, err := client.LoadBalancers.Delete(ctx, lb.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -4,17 +4,18 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
-	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/apprenda/kismatic-provision/provision/bootstrap"
 	"github.com/apprenda/kismatic-provision/provision/plan"
+	"github.com/apprenda/kismatic-provision/provision/remote"
 )
 
 const (
-	SSHKEY          = "apprenda-key"
-	KET_INSTALL_DIR = "/ket"
+	SSHKEY = "apprenda-key"
 )
 
 type infrastructureProvisioner interface {
@@ -56,6 +57,15 @@ func (p ProvisionedNodes) allNodes() []plan.Node {
 	return n
 }
 
+func (p ProvisionedNodes) cluster() remote.Cluster {
+	return remote.Cluster{
+		"etcd":      p.Etcd,
+		"master":    p.Master,
+		"worker":    p.Worker,
+		"bootstrap": p.Boostrap,
+	}
+}
+
 type sshMachineProvisioner struct {
 	sshKey string
 }
@@ -77,7 +87,7 @@ func GetProvisioner() (*doProvisioner, bool) {
 
 func dropletToNode(drop *Droplet, opts *DOOpts) plan.Node {
 	node := plan.Node{}
-	node.ID = string(drop.ID)
+	node.ID = strconv.Itoa(drop.ID)
 	node.Host = drop.Name
 	node.PublicIPv4 = drop.PublicIP
 	node.PrivateIPv4 = drop.PrivateIP
@@ -128,10 +138,18 @@ func (p doProvisioner) ProvisionNodes(opts DOOpts, nodeCount NodeCount) (Provisi
 		return provisioned, errkey
 	}
 
+	userData, err := opts.UserData.Templates()
+	if err != nil {
+		return provisioned, err
+	}
 	var dropletsETCD []Droplet
 	var i uint16
 	for i = 0; i < nodeCount.Etcd; i++ {
-		config := optionsToConfig(&opts, fmt.Sprintf("etcd%d", i+1), "", "")
+		ud, err := userData.Render(opts.ClusterTag, "etcd", int(i))
+		if err != nil {
+			return provisioned, err
+		}
+		config := optionsToConfig(&opts, fmt.Sprintf("etcd%d", i+1), "", ud)
 		drop, err := p.client.CreateNode(opts.Token, config, key)
 		if err != nil {
 			return provisioned, err
@@ -140,7 +158,14 @@ func (p doProvisioner) ProvisionNodes(opts DOOpts, nodeCount NodeCount) (Provisi
 	}
 	var dropletsMaster []Droplet
 	for i = 0; i < nodeCount.Master; i++ {
-		config := optionsToConfig(&opts, fmt.Sprintf("master%d", i+1), "", "")
+		ud, err := userData.Render(opts.ClusterTag, "master", int(i))
+		if err != nil {
+			return provisioned, err
+		}
+		config := optionsToConfig(&opts, fmt.Sprintf("master%d", i+1), "", ud)
+		if opts.MasterLB {
+			config.Tags = append(config.Tags, masterTag(opts.ClusterTag))
+		}
 		drop, err := p.client.CreateNode(opts.Token, config, key)
 		if err != nil {
 			return provisioned, err
@@ -149,7 +174,11 @@ func (p doProvisioner) ProvisionNodes(opts DOOpts, nodeCount NodeCount) (Provisi
 	}
 	var dropletsWorker []Droplet
 	for i = 0; i < nodeCount.Worker; i++ {
-		config := optionsToConfig(&opts, fmt.Sprintf("worker%d", i+1), opts.WorkerType, "")
+		ud, err := userData.Render(opts.ClusterTag, "worker", int(i))
+		if err != nil {
+			return provisioned, err
+		}
+		config := optionsToConfig(&opts, fmt.Sprintf("worker%d", i+1), opts.WorkerType, ud)
 		drop, err := p.client.CreateNode(opts.Token, config, key)
 		if err != nil {
 			return provisioned, err
@@ -237,6 +266,21 @@ func (p doProvisioner) WaitForIPs(opts DOOpts, drop Droplet) *Droplet {
 	}
 }
 
+// Cluster returns the droplets tagged with the cluster tag, grouped by role. The
+// role of a droplet is derived from its name (e.g. "master2" is a master node).
+func (p doProvisioner) Cluster(opts DOOpts) (remote.Cluster, error) {
+	drops, err := p.client.ListDropletsByTag(opts.Token, opts.ClusterTag)
+	if err != nil {
+		return nil, err
+	}
+	cluster := remote.Cluster{}
+	for i := range drops {
+		role := strings.TrimRight(drops[i].Name, "0123456789")
+		cluster[role] = append(cluster[role], dropletToNode(&drops[i], &opts))
+	}
+	return cluster, nil
+}
+
 func (p doProvisioner) TerminateNodes(opts DOOpts) error {
 
 	key := ""
@@ -244,6 +288,12 @@ func (p doProvisioner) TerminateNodes(opts DOOpts) error {
 		key = SSHKEY
 	}
 
+	// Without a cluster tag, no load balancer can have been created
+	if opts.ClusterTag != "" {
+		if err := p.client.DeleteLoadBalancerByName(opts.Token, lbName(opts.ClusterTag)); err != nil {
+			return err
+		}
+	}
 	return p.client.DeleteDropletsByTag(opts.Token, opts.ClusterTag, key)
 }
 
@@ -257,13 +307,7 @@ func WaitForSSH(ProvisionedNodes ProvisionedNodes, sshKey string) error {
 }
 
 func loadBootCmds(path string) (string, error) {
-	dir, err := filepath.Abs(filepath.Dir(os.Args[0]))
-	if err != nil {
-		return "", fmt.Errorf("Cannot get path to exec %v\n", err)
-	}
-
-	cmdpath := filepath.Join(dir, path)
-	cmd, errcmd := ioutil.ReadFile(cmdpath)
+	cmd, errcmd := ioutil.ReadFile(path)
 	if errcmd != nil {
 		fmt.Println("Cannot read public boot init file", errcmd)
 		return "", errcmd
@@ -272,7 +316,7 @@ func loadBootCmds(path string) (string, error) {
 
 	root := os.Getenv("DO_KET_INSTALL_DIR")
 	if root == "" {
-		root = KET_INSTALL_DIR
+		root = bootstrap.DefaultInstallDir
 	}
 	initstatement := fmt.Sprintf("#!/bin/bash\nmkdir -p %s\ncd %s && ", root, root)
 	s = strings.Replace(s, "#!/bin/bash", initstatement, -1)
@@ -0,0 +1,224 @@
+package install
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/apprenda/kismatic-provision/provision/bootstrap"
+	"github.com/spf13/cobra"
+)
+
+const kubeconfig = "generated/kubeconfig"
+
+// Opts are the options for installing the cluster once it has been provisioned
+type Opts struct {
+	Enabled         bool
+	KismaticPath    string
+	KismaticVersion string
+	// KismaticChecksum is the checksum of the linux release tarball of KismaticVersion
+	KismaticChecksum   string
+	InsecureSkipVerify bool
+}
+
+// AddFlags adds the install flags to the create command
+func AddFlags(cmd *cobra.Command, opts *Opts) {
+	cmd.Flags().BoolVar(&opts.Enabled, "install", false, "If present, runs 'kismatic install validate' and 'kismatic install apply' against the generated plan file.")
+	cmd.Flags().StringVar(&opts.KismaticPath, "kismatic-path", "", "Path to the kismatic executable used with --install. If empty, kismatic is looked up in the current directory and in the PATH, and downloaded if it cannot be found.")
+}
+
+// SetRelease selects the release of KET that is installed on the bootstrap node, which is
+// downloaded and verified the same way when kismatic cannot be found.
+func (opts *Opts) SetRelease(b bootstrap.Opts) {
+	opts.KismaticVersion = b.KETVersion
+	opts.KismaticChecksum = b.KETChecksum
+	opts.InsecureSkipVerify = b.InsecureSkipVerify
+}
+
+// Validate checks that the release of KET can be verified, if it has to be downloaded
+func (opts Opts) Validate() error {
+	if !opts.Enabled || opts.KismaticPath != "" || opts.InsecureSkipVerify || opts.checksum() != "" {
+		return nil
+	}
+	if _, err := opts.find(); err == nil {
+		return nil
+	}
+	return fmt.Errorf("kismatic was not found and no checksum is known for the %s release of KET %s. Use --kismatic-path, --ket-sha256 on linux, or --insecure-skip-verify", runtime.GOOS, opts.version())
+}
+
+// Locate returns the path to the kismatic executable. If it cannot be found,
+// the requested version of KET is downloaded to the current directory.
+func (opts Opts) Locate() (string, error) {
+	if opts.KismaticPath != "" {
+		if _, err := os.Stat(opts.KismaticPath); err != nil {
+			return "", fmt.Errorf("cannot use kismatic at %q: %v", opts.KismaticPath, err)
+		}
+		return opts.KismaticPath, nil
+	}
+	if p, err := opts.find(); err == nil {
+		return p, nil
+	}
+	return download(opts.version(), opts.checksum(), opts.InsecureSkipVerify)
+}
+
+// find looks up kismatic in the current directory and in the PATH
+func (opts Opts) find() (string, error) {
+	if _, err := os.Stat("kismatic"); err == nil {
+		return "./kismatic", nil
+	}
+	return exec.LookPath("kismatic")
+}
+
+// checksum returns the checksum of the release tarball for this OS, or an empty string
+// if there is none.
+func (opts Opts) checksum() string {
+	if opts.KismaticChecksum != "" && runtime.GOOS == "linux" {
+		return opts.KismaticChecksum
+	}
+	return bootstrap.PinnedKETChecksum(opts.version(), runtime.GOOS)
+}
+
+func (opts Opts) version() string {
+	if opts.KismaticVersion == "" {
+		return bootstrap.DefaultKETVersion
+	}
+	return opts.KismaticVersion
+}
+
+// Run validates the plan file and installs the cluster using the local kismatic
+// executable. The output of kismatic is streamed to stdout.
+func (opts Opts) Run(planFile string) error {
+	kismatic, err := opts.Locate()
+	if err != nil {
+		return err
+	}
+	// KET expects its ansible directory to be next to the executable, and writes
+	// the generated assets relative to the working directory.
+	for _, step := range []string{"validate", "apply"} {
+		fmt.Printf("Running kismatic install %s -f %s\n", step, planFile)
+		cmd := exec.Command(kismatic, "install", step, "-f", planFile)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("kismatic install %s failed: %v", step, err)
+		}
+	}
+	wd, _ := os.Getwd()
+	fmt.Println("Your cluster is installed. The kubeconfig file is at:")
+	fmt.Println(filepath.Join(wd, kubeconfig))
+	return nil
+}
+
+// download fetches the KET release for this OS into a kismatic-<version> directory
+// and returns the path to the kismatic executable. The release is only extracted once
+// its checksum is verified, which can only be skipped when no checksum is known.
+func download(version, checksum string, skipVerify bool) (string, error) {
+	dir := "kismatic-" + version
+	kismatic := filepath.Join(dir, "kismatic")
+	if _, err := os.Stat(kismatic); err == nil {
+		return kismatic, nil
+	}
+	url := fmt.Sprintf("https://github.com/apprenda/kismatic/releases/download/%s/kismatic-%s-%s-amd64.tar.gz", version, version, runtime.GOOS)
+	fmt.Printf("Downloading KET %s from %s\n", version, url)
+	resp, err := http.Get(url)
+	if err != nil {
+		return "", fmt.Errorf("error downloading KET: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error downloading KET: got status %s", resp.Status)
+	}
+	f, err := ioutil.TempFile("", "kismatic")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), resp.Body); err != nil {
+		return "", fmt.Errorf("error downloading KET: %v", err)
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	switch {
+	case checksum != "" && sum != checksum:
+		return "", fmt.Errorf("the checksum of the KET download is %s, expected %s", sum, checksum)
+	case checksum == "" && !skipVerify:
+		return "", fmt.Errorf("no checksum is known for KET %s on %s", version, runtime.GOOS)
+	case checksum == "":
+		fmt.Printf("WARNING: the KET download is not verified, as no checksum is known\n")
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	if err := untar(f, dir); err != nil {
+		return "", fmt.Errorf("error extracting KET: %v", err)
+	}
+	return kismatic, nil
+}
+
+func untar(r io.Reader, dir string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dir, hdr.Name)
+		// Only the directory entry of the root can resolve to the directory itself
+		if !within(dir, target) || (target == filepath.Clean(dir) && hdr.Typeflag != tar.TypeDir) {
+			return fmt.Errorf("invalid path in archive: %q", hdr.Name)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, os.FileMode(hdr.Mode)); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(hdr.Mode))
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			// Links cannot point outside of the directory, or later entries could be
+			// written through them
+			if filepath.IsAbs(hdr.Linkname) || !within(dir, filepath.Join(filepath.Dir(target), hdr.Linkname)) {
+				return fmt.Errorf("invalid link in archive: %q -> %q", hdr.Name, hdr.Linkname)
+			}
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// within returns whether the path is the directory or is inside of it
+func within(dir, path string) bool {
+	dir = filepath.Clean(dir)
+	return path == dir || strings.HasPrefix(path, dir+string(os.PathSeparator))
+}
@@ -0,0 +1,94 @@
+package install
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type entry struct {
+	name     string
+	typeflag byte
+	linkname string
+	content  string
+}
+
+func tarball(t *testing.T, entries []entry) *bytes.Buffer {
+	var b bytes.Buffer
+	gz := gzip.NewWriter(&b)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typeflag, Linkname: e.linkname, Mode: 0755, Size: int64(len(e.content))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("error writing header: %v", err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatalf("error writing content: %v", err)
+		}
+	}
+	tw.Close()
+	gz.Close()
+	return &b
+}
+
+func TestUntar(t *testing.T) {
+	tests := []struct {
+		entries []entry
+		err     string
+	}{
+		{
+			entries: []entry{
+				{name: "./", typeflag: tar.TypeDir},
+				{name: "./ansible/", typeflag: tar.TypeDir},
+				{name: "./kismatic", typeflag: tar.TypeReg, content: "kismatic"},
+				{name: "./ansible/link", typeflag: tar.TypeSymlink, linkname: "../kismatic"},
+			},
+		},
+		{
+			entries: []entry{{name: "../outside", typeflag: tar.TypeReg, content: "kismatic"}},
+			err:     "invalid path",
+		},
+		{
+			entries: []entry{{name: "../kismatic", typeflag: tar.TypeReg, content: "kismatic"}},
+			err:     "invalid path",
+		},
+		{
+			entries: []entry{{name: "link", typeflag: tar.TypeSymlink, linkname: "/etc"}},
+			err:     "invalid link",
+		},
+		{
+			entries: []entry{
+				{name: "ansible/", typeflag: tar.TypeDir},
+				{name: "ansible/link", typeflag: tar.TypeSymlink, linkname: "../../outside"},
+			},
+			err: "invalid link",
+		},
+	}
+	for i, test := range tests {
+		tmp, err := ioutil.TempDir("", "untar")
+		if err != nil {
+			t.Fatalf("error creating temp dir: %v", err)
+		}
+		defer os.RemoveAll(tmp)
+		dir := filepath.Join(tmp, "kismatic")
+		err = untar(tarball(t, test.entries), dir)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("test %d: expected error containing %q, got %v", i, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d: unexpected error: %v", i, err)
+			continue
+		}
+		if b, err := ioutil.ReadFile(filepath.Join(dir, "ansible", "link")); err != nil || string(b) != "kismatic" {
+			t.Errorf("test %d: could not read the extracted link: %q, %v", i, b, err)
+		}
+	}
+}
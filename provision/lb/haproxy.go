@@ -0,0 +1,130 @@
+package lb
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"text/template"
+	"time"
+
+	"github.com/apprenda/kismatic-provision/provision/plan"
+	"github.com/apprenda/kismatic-provision/provision/remote"
+)
+
+// Role is the role of the load balancer node in the cluster
+const Role = "lb"
+
+const (
+	apiServerPort = 6443
+	timeout       = 10 * time.Minute
+)
+
+const haproxyConfig = `global
+    log /dev/log local0
+    maxconn 2000
+
+defaults
+    mode tcp
+    log global
+    option tcplog
+    timeout connect 5s
+    timeout client 1h
+    timeout server 1h
+
+frontend kubernetes-api
+    bind *:{{.Port}}
+    default_backend kubernetes-masters
+
+backend kubernetes-masters
+    balance roundrobin
+    option tcp-check{{range $i, $m := .Masters}}
+    server master{{$i}} {{$m}}:{{$.Port}} check fall 3 rise 2{{end}}
+`
+
+// installScript installs HAProxy and moves the uploaded configuration in place. On
+// SELinux systems HAProxy is not allowed to bind to the API server port by default.
+const installScript = `set -e
+if command -v apt-get >/dev/null; then
+  sudo apt-get update -qq
+  sudo DEBIAN_FRONTEND=noninteractive apt-get install -y -qq haproxy
+else
+  sudo yum install -y -q haproxy
+fi
+if command -v selinuxenabled >/dev/null && selinuxenabled; then
+  sudo setsebool -P haproxy_connect_any 1
+fi
+sudo mv /tmp/haproxy.cfg /etc/haproxy/haproxy.cfg
+sudo systemctl enable haproxy
+sudo systemctl restart haproxy
+`
+
+// Config returns the HAProxy configuration that balances the API server port
+// across the masters. The private IP of each master is used when it has one.
+func Config(masters []plan.Node) (string, error) {
+	t, err := template.New("haproxy").Parse(haproxyConfig)
+	if err != nil {
+		return "", err
+	}
+	ips := []string{}
+	for _, m := range masters {
+		ip := m.PrivateIPv4
+		if ip == "" {
+			ip = m.PublicIPv4
+		}
+		ips = append(ips, ip)
+	}
+	var b bytes.Buffer
+	data := struct {
+		Port    int
+		Masters []string
+	}{apiServerPort, ips}
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// Configure installs HAProxy on the node and configures it to balance the API
+// server port across the masters.
+func Configure(node plan.Node, sshKey string, masters []plan.Node) error {
+	cfg, err := Config(masters)
+	if err != nil {
+		return err
+	}
+	cfgFile, err := writeTemp(cfg)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(cfgFile)
+	scriptFile, err := writeTemp(installScript)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(scriptFile)
+
+	fmt.Printf("Configuring HAProxy on %s\n", node.Host)
+	if err := remote.CopyFileToRemote(cfgFile, "/tmp/haproxy.cfg", node, sshKey, timeout); err != nil {
+		return fmt.Errorf("error uploading HAProxy configuration: %v", err)
+	}
+	if err := remote.CopyFileToRemote(scriptFile, "/tmp/install-haproxy.sh", node, sshKey, timeout); err != nil {
+		return fmt.Errorf("error uploading HAProxy install script: %v", err)
+	}
+	if err := remote.RunViaSSH([]string{"bash /tmp/install-haproxy.sh"}, []plan.Node{node}, sshKey, timeout); err != nil {
+		return fmt.Errorf("error installing HAProxy: %v", err)
+	}
+	return nil
+}
+
+func writeTemp(content string) (string, error) {
+	f, err := ioutil.TempFile("", "kismatic-lb")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
@@ -59,11 +59,12 @@ func newFromEnv() (*Client, error) {
 	}, nil
 }
 
-// CreateNode creates a node in packet with the given hostname and OS
-func (c Client) CreateNode(hostname string, os OS, region Region) (string, error) {
+// CreateNode creates a node in packet with the given hostname, OS and user data
+func (c Client) CreateNode(hostname string, os OS, region Region, userData string) (string, error) {
 	device := &packngo.DeviceCreateRequest{
 		HostName:     hostname,
 		OS:           string(os),
+		UserData:     userData,
 		Tags:         []string{"integration-test"},
 		ProjectID:    c.ProjectID,
 		Plan:         "baremetal_0",
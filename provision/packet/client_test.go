@@ -17,7 +17,7 @@ func TestClient(t *testing.T) {
 
 	hostname := "testNode"
 	osImage := CentOS7
-	deviceID, err := client.CreateNode(hostname, osImage, USEast)
+	deviceID, err := client.CreateNode(hostname, osImage, USEast, "")
 	if err != nil {
 		t.Errorf("failed to create node: %v", err)
 	}
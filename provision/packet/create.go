@@ -7,11 +7,20 @@ import (
 	"text/template"
 	"time"
 
+	"github.com/apprenda/kismatic-provision/provision/bootstrap"
+	"github.com/apprenda/kismatic-provision/provision/install"
+	"github.com/apprenda/kismatic-provision/provision/lb"
 	"github.com/apprenda/kismatic-provision/provision/plan"
+	"github.com/apprenda/kismatic-provision/provision/remote"
+	"github.com/apprenda/kismatic-provision/provision/userdata"
+	"github.com/apprenda/kismatic-provision/provision/validate"
 
 	"github.com/spf13/cobra"
 )
 
+// clusterName is the prefix of the hostnames of the machines created by this tool
+const clusterName = "kismatic"
+
 func createCmd() *cobra.Command {
 	opts := &packetOpts{}
 	cmd := &cobra.Command{
@@ -23,6 +32,7 @@ provision packet create -e 3 -m 2 -w 3
 # Create 1 etcd node, 1 master node and 1 worker node using CentOS 7
 provision packet create --useCentos`,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			opts.Install.SetRelease(opts.Bootstrap)
 			return runCreate(opts)
 		},
 	}
@@ -33,6 +43,13 @@ provision packet create --useCentos`,
 	cmd.Flags().BoolVarP(&opts.NoPlan, "noplan", "n", false, "If present, foregoes generating a plan file in this directory referencing the newly created nodes")
 	cmd.Flags().StringVar(&opts.Region, "region", "us-east", "The region to be used for provisioning machines. One of us-east|us-west|eu-west")
 	cmd.Flags().BoolVarP(&opts.Storage, "storage-cluster", "s", false, "Create a storage cluster from all Worker nodes.")
+	cmd.Flags().BoolVar(&opts.LoadBalancer, "lb", false, "Create a load balancer node running HAProxy in front of the master nodes, and use it as the load balancer of the plan.")
+	cmd.Flags().BoolVar(&opts.BootstrapNode, "bootstrap", false, "Create a bootstrap node with KET and kubectl installed, from which the cluster can be installed and managed.")
+	bootstrap.AddFlags(cmd, &opts.Bootstrap)
+	userdata.AddFlags(cmd, &opts.UserData)
+	remote.AddHookFlags(cmd, &opts.Hooks)
+	validate.AddFlags(cmd, &opts.Validate)
+	install.AddFlags(cmd, &opts.Install)
 
 	return cmd
 }
@@ -56,28 +73,57 @@ func runCreate(opts *packetOpts) error {
 	if err != nil {
 		return err
 	}
+	if _, err := opts.Hooks.Hooks(); err != nil {
+		return err
+	}
+	if err := opts.UserData.Validate(); err != nil {
+		return err
+	}
+	if opts.BootstrapNode {
+		if err := opts.Bootstrap.Validate(); err != nil {
+			return err
+		}
+	}
+	if opts.Install.Enabled && opts.NoPlan {
+		return fmt.Errorf("--install cannot be used with --noplan")
+	}
+	if !opts.BootstrapNode {
+		if err := opts.Install.Validate(); err != nil {
+			return err
+		}
+	}
 
 	distro := Ubuntu1604LTS
 	if opts.CentOS {
 		distro = CentOS7
 	}
 	provTime := strconv.FormatInt(time.Now().Unix(), 10)
-	generateHostname := hostnameGenerator("kismatic", provTime)
+	generateHostname := hostnameGenerator(clusterName, provTime)
 	nodeIDs := struct {
-		etcd   []string
-		master []string
-		worker []string
+		etcd      []string
+		master    []string
+		worker    []string
+		bootstrap []string
+		lb        []string
 	}{}
 	region, err := regionFromString(opts.Region)
 	if err != nil {
 		return err
 	}
 
+	userData, err := opts.UserData.Templates()
+	if err != nil {
+		return err
+	}
 	fmt.Println("Provisioning nodes")
 	var i uint16
 	for i = 0; i < opts.EtcdNodeCount; i++ {
 		hostname := generateHostname("etcd", int(i))
-		nodeID, err := c.CreateNode(hostname, distro, region)
+		ud, err := userData.Render(clusterName, "etcd", int(i))
+		if err != nil {
+			return err
+		}
+		nodeID, err := c.CreateNode(hostname, distro, region, ud)
 		if err != nil {
 			return err
 		}
@@ -85,7 +131,11 @@ func runCreate(opts *packetOpts) error {
 	}
 	for i = 0; i < opts.MasterNodeCount; i++ {
 		hostname := generateHostname("master", int(i))
-		nodeID, err := c.CreateNode(hostname, distro, region)
+		ud, err := userData.Render(clusterName, "master", int(i))
+		if err != nil {
+			return err
+		}
+		nodeID, err := c.CreateNode(hostname, distro, region, ud)
 		if err != nil {
 			return err
 		}
@@ -93,18 +143,40 @@ func runCreate(opts *packetOpts) error {
 	}
 	for i = 0; i < opts.WorkerNodeCount; i++ {
 		hostname := generateHostname("worker", int(i))
-		nodeID, err := c.CreateNode(hostname, distro, region)
+		ud, err := userData.Render(clusterName, "worker", int(i))
+		if err != nil {
+			return err
+		}
+		nodeID, err := c.CreateNode(hostname, distro, region, ud)
 		if err != nil {
 			return err
 		}
 		nodeIDs.worker = append(nodeIDs.worker, nodeID)
 	}
+	if opts.BootstrapNode {
+		hostname := generateHostname("bootstrap", 0)
+		nodeID, err := c.CreateNode(hostname, distro, region, "")
+		if err != nil {
+			return err
+		}
+		nodeIDs.bootstrap = append(nodeIDs.bootstrap, nodeID)
+	}
+	if opts.LoadBalancer {
+		hostname := generateHostname(lb.Role, 0)
+		nodeID, err := c.CreateNode(hostname, distro, region, "")
+		if err != nil {
+			return err
+		}
+		nodeIDs.lb = append(nodeIDs.lb, nodeID)
+	}
 
 	fmt.Println("Waiting for nodes to be accessible via SSH. This takes a while...")
 	nodes := struct {
-		etcd   []plan.Node
-		master []plan.Node
-		worker []plan.Node
+		etcd      []plan.Node
+		master    []plan.Node
+		worker    []plan.Node
+		bootstrap []plan.Node
+		lb        []plan.Node
 	}{}
 	for _, id := range nodeIDs.etcd {
 		node, err := c.GetSSHAccessibleNode(id, 15*time.Minute, c.SSHKey)
@@ -127,9 +199,47 @@ func runCreate(opts *packetOpts) error {
 		}
 		nodes.worker = append(nodes.worker, *node)
 	}
+	for _, id := range nodeIDs.bootstrap {
+		node, err := c.GetSSHAccessibleNode(id, 15*time.Minute, c.SSHKey)
+		if err != nil {
+			return fmt.Errorf("error waiting for node to be ready")
+		}
+		nodes.bootstrap = append(nodes.bootstrap, *node)
+	}
+	for _, id := range nodeIDs.lb {
+		node, err := c.GetSSHAccessibleNode(id, 15*time.Minute, c.SSHKey)
+		if err != nil {
+			return fmt.Errorf("error waiting for node to be ready")
+		}
+		nodes.lb = append(nodes.lb, *node)
+	}
 	fmt.Println()
 	fmt.Printf("Finished provisioning nodes on Packet.net in %s\n", time.Now().Sub(startTime))
 
+	cluster := remote.Cluster{"etcd": nodes.etcd, "master": nodes.master, "worker": nodes.worker}
+	if opts.BootstrapNode {
+		cluster["bootstrap"] = nodes.bootstrap
+	}
+	if opts.LoadBalancer {
+		cluster[lb.Role] = nodes.lb
+	}
+	if err := opts.Hooks.RunHooks(cluster, c.SSHKey); err != nil {
+		return err
+	}
+	if err := opts.Validate.Run(cluster, c.SSHKey); err != nil {
+		return err
+	}
+
+	loadBalancer := nodes.master[0].PublicIPv4
+	var extraSANs string
+	if opts.LoadBalancer {
+		if err := lb.Configure(nodes.lb[0], c.SSHKey, nodes.master); err != nil {
+			return err
+		}
+		loadBalancer = nodes.lb[0].PublicIPv4
+		extraSANs = nodes.lb[0].PublicIPv4
+	}
+
 	if opts.NoPlan {
 		fmt.Println("Etcd:")
 		for _, n := range nodes.etcd {
@@ -143,6 +253,14 @@ func runCreate(opts *packetOpts) error {
 		for _, n := range nodes.worker {
 			printNode(n)
 		}
+		if opts.BootstrapNode {
+			fmt.Println("Bootstrap:")
+			printNode(nodes.bootstrap[0])
+		}
+		if opts.LoadBalancer {
+			fmt.Println("Load Balancer:")
+			printNode(nodes.lb[0])
+		}
 		return nil
 	}
 
@@ -151,16 +269,24 @@ func runCreate(opts *packetOpts) error {
 		storageNodes = nodes.worker
 	}
 
+	// If the user asks for a bootstrap node, the generated plan file will contain
+	// the path to the SSH key on the bootstrap node.
+	sshKeyFile := c.SSHKey
+	if opts.BootstrapNode {
+		sshKeyFile = opts.Bootstrap.KeyPath(c.SSHKey)
+	}
+
 	// Write the plan file out
 	planit := plan.Plan{
-		Etcd:         nodes.etcd,
-		Master:       nodes.master,
-		Worker:       nodes.worker,
-		Ingress:      nodes.worker[0:1],
-		Storage:      storageNodes,
-		LoadBalancer: nodes.master[0].PublicIPv4 + ":6443",
-		SSHUser:      nodes.master[0].SSHUser,
-		SSHKeyFile:   c.SSHKey,
+		Etcd:                   nodes.etcd,
+		Master:                 nodes.master,
+		Worker:                 nodes.worker,
+		Ingress:                nodes.worker[0:1],
+		Storage:                storageNodes,
+		LoadBalancer:           loadBalancer + ":6443",
+		APIServerCertExtraSANs: extraSANs,
+		SSHUser:                nodes.master[0].SSHUser,
+		SSHKeyFile:             sshKeyFile,
 	}
 
 	template, err := template.New("plan").Parse(plan.OverlayNetworkPlan)
@@ -168,9 +294,26 @@ func runCreate(opts *packetOpts) error {
 		return err
 	}
 	f, err := makeUniqueFile(0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
 	if err := template.Execute(f, planit); err != nil {
 		return err
 	}
+	if opts.BootstrapNode {
+		if err := opts.Bootstrap.Prepare(nodes.bootstrap[0], c.SSHKey, f.Name()); err != nil {
+			return err
+		}
+		if opts.Install.Enabled {
+			return opts.Bootstrap.Install(nodes.bootstrap[0], c.SSHKey)
+		}
+		opts.Bootstrap.PrintInstructions(nodes.bootstrap[0], c.SSHKey)
+		return nil
+	}
+	if opts.Install.Enabled {
+		return opts.Install.Run(f.Name())
+	}
 	fmt.Println("To install your cluster, run:")
 	fmt.Println("./kismatic install apply -f " + f.Name())
 	return nil
@@ -6,7 +6,11 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/apprenda/kismatic-provision/provision/install"
 	"github.com/apprenda/kismatic-provision/provision/plan"
+	"github.com/apprenda/kismatic-provision/provision/remote"
+	"github.com/apprenda/kismatic-provision/provision/userdata"
+	"github.com/apprenda/kismatic-provision/provision/validate"
 	"github.com/spf13/cobra"
 )
 
@@ -23,6 +27,10 @@ func createMinikubeCmd() *cobra.Command {
 	cmd.Flags().BoolVarP(&opts.NoPlan, "noplan", "n", false, "If present, foregoes generating a plan file in this directory referencing the newly created nodes")
 	cmd.Flags().StringVar(&opts.Region, "region", "us-east", "The region to be used for provisioning machines. One of us-east|us-west|eu-west")
 	cmd.Flags().BoolVarP(&opts.Storage, "storage-cluster", "s", false, "Create a storage cluster from all Worker nodes.")
+	userdata.AddFlags(cmd, &opts.UserData)
+	remote.AddHookFlags(cmd, &opts.Hooks)
+	validate.AddFlags(cmd, &opts.Validate)
+	install.AddFlags(cmd, &opts.Install)
 
 	return cmd
 }
@@ -33,6 +41,18 @@ func runCreateMinikube(opts *packetOpts) error {
 	if err != nil {
 		return err
 	}
+	if _, err := opts.Hooks.Hooks(); err != nil {
+		return err
+	}
+	if err := opts.UserData.Validate(); err != nil {
+		return err
+	}
+	if opts.Install.Enabled && opts.NoPlan {
+		return fmt.Errorf("--install cannot be used with --noplan")
+	}
+	if err := opts.Install.Validate(); err != nil {
+		return err
+	}
 
 	distro := Ubuntu1604LTS
 	if opts.CentOS {
@@ -45,8 +65,16 @@ func runCreateMinikube(opts *packetOpts) error {
 	}
 
 	fmt.Println("Provisioning node")
-	hostname := fmt.Sprintf("kismatic-node-%s", provTime)
-	nodeID, err := c.CreateNode(hostname, distro, region)
+	hostname := fmt.Sprintf("%s-node-%s", clusterName, provTime)
+	userData, err := opts.UserData.Templates()
+	if err != nil {
+		return err
+	}
+	ud, err := userData.Render(clusterName, "worker", 0)
+	if err != nil {
+		return err
+	}
+	nodeID, err := c.CreateNode(hostname, distro, region, ud)
 	if err != nil {
 		return err
 	}
@@ -60,6 +88,14 @@ func runCreateMinikube(opts *packetOpts) error {
 	fmt.Println()
 	fmt.Printf("Finished provisioning nodes on Packet.net in %s\n", time.Now().Sub(startTime))
 
+	minikube := remote.Cluster{"etcd": {*node}, "master": {*node}, "worker": {*node}}
+	if err := opts.Hooks.RunHooks(minikube, c.SSHKey); err != nil {
+		return err
+	}
+	if err := opts.Validate.Run(minikube, c.SSHKey); err != nil {
+		return err
+	}
+
 	if opts.NoPlan {
 		fmt.Println("")
 		fmt.Printf("%+v", node)
@@ -87,9 +123,16 @@ func runCreateMinikube(opts *packetOpts) error {
 		SSHKeyFile:   c.SSHKey,
 	}
 	f, err := makeUniqueFile(0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
 	if err := template.Execute(f, plan); err != nil {
 		return err
 	}
+	if opts.Install.Enabled {
+		return opts.Install.Run(f.Name())
+	}
 	fmt.Println("To install your cluster, run:")
 	fmt.Println("./kismatic install apply -f " + f.Name())
 
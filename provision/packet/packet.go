@@ -1,6 +1,13 @@
 package packet
 
-import "github.com/spf13/cobra"
+import (
+	"github.com/apprenda/kismatic-provision/provision/bootstrap"
+	"github.com/apprenda/kismatic-provision/provision/install"
+	"github.com/apprenda/kismatic-provision/provision/remote"
+	"github.com/apprenda/kismatic-provision/provision/userdata"
+	"github.com/apprenda/kismatic-provision/provision/validate"
+	"github.com/spf13/cobra"
+)
 
 type packetOpts struct {
 	EtcdNodeCount   uint16
@@ -10,6 +17,13 @@ type packetOpts struct {
 	NoPlan          bool
 	Region          string
 	Storage         bool
+	BootstrapNode   bool
+	Bootstrap       bootstrap.Opts
+	Hooks           remote.HookOpts
+	UserData        userdata.Opts
+	Install         install.Opts
+	Validate        validate.Opts
+	LoadBalancer    bool
 }
 
 // Cmd returns the command for managing Packet infrastructure
@@ -34,5 +48,6 @@ Optional:
 	cmd.AddCommand(createMinikubeCmd())
 	cmd.AddCommand(deleteCmd())
 	cmd.AddCommand(listCmd())
+	cmd.AddCommand(sshCmd())
 	return cmd
 }
@@ -0,0 +1,76 @@
+package packet
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/apprenda/kismatic-provision/provision/remote"
+	"github.com/spf13/cobra"
+)
+
+// matches the hostnames generated by hostnameGenerator, e.g. kismatic-master-0-1527620000
+var generatedHostname = regexp.MustCompile(`^[^-]+-([a-z]+)-(\d+)-\d+$`)
+
+func sshCmd() *cobra.Command {
+	var timeout time.Duration
+	cmd := &cobra.Command{
+		Use:   "ssh HOSTNAME|ROLE|ROLE[INDEX] [-- COMMAND]",
+		Short: "Connects to a machine in the Packet.net project using SSH.",
+		Long: `Connects to a machine in the Packet.net project using SSH.
+
+Machines can be selected by hostname, public or private IP, or by role and index (e.g. master[0]).
+When a role is given without an index, the command is run on all the machines of that role in parallel.`,
+		Example: `# Open a shell on the first master node
+provision packet ssh master[0]
+
+# Check the uptime of all worker nodes
+provision packet ssh worker -- uptime`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			selector, command, err := remote.SplitArgs(cmd, args)
+			if err != nil {
+				return err
+			}
+			return runSSH(selector, command, timeout)
+		},
+	}
+	cmd.Flags().DurationVar(&timeout, "timeout", 10*time.Minute, "Maximum time to wait for a command to complete on all machines.")
+	return cmd
+}
+
+func runSSH(selector string, command []string, timeout time.Duration) error {
+	client, err := newFromEnv()
+	if err != nil {
+		return err
+	}
+	cluster, err := listCluster(client)
+	if err != nil {
+		return err
+	}
+	return remote.SSH(cluster, selector, command, client.SSHKey, timeout)
+}
+
+// listCluster returns the machines in the project grouped by role. The role and
+// the order of the machines are derived from the generated hostnames. Machines that
+// were not created by this tool are grouped under the "other" role.
+func listCluster(client *Client) (remote.Cluster, error) {
+	nodes, err := client.ListNodes()
+	if err != nil {
+		return nil, err
+	}
+	index := map[string]int{}
+	cluster := remote.Cluster{}
+	for _, n := range nodes {
+		role := "other"
+		if m := generatedHostname.FindStringSubmatch(n.Host); m != nil {
+			role = m[1]
+			index[n.Host], _ = strconv.Atoi(m[2])
+		}
+		cluster[role] = append(cluster[role], n)
+	}
+	for _, nodes := range cluster {
+		sort.SliceStable(nodes, func(i, j int) bool { return index[nodes[i].Host] < index[nodes[j].Host] })
+	}
+	return cluster, nil
+}
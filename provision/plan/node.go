@@ -6,4 +6,9 @@ type Node struct {
 	PublicIPv4  string
 	PrivateIPv4 string
 	SSHUser     string
+	// Zone is the availability zone of the node, when known
+	Zone string
+	// Bastion is the host, optionally prefixed with a user, through which the node is
+	// reached over SSH. Empty when the node can be reached directly.
+	Bastion string
 }
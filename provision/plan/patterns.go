@@ -1,16 +1,27 @@
 package plan
 
 type Plan struct {
-	Etcd         []Node
-	Master       []Node
-	Worker       []Node
-	Ingress      []Node
-	Storage      []Node
-	LoadBalancer string
-	SSHUser      string
-	SSHKeyFile   string
+	Etcd                   []Node
+	Master                 []Node
+	Worker                 []Node
+	Ingress                []Node
+	Storage                []Node
+	LoadBalancer           string
+	APIServerCertExtraSANs string
+	SSHUser                string
+	SSHKeyFile             string
+	// DockerBlockDevice is the device used by docker in direct-lvm mode, if any
+	DockerBlockDevice string
+	// CloudProvider is the Kubernetes cloud provider, if any
+	CloudProvider string
 }
 
+// The pod and service networks of OverlayNetworkPlan
+const (
+	PodCIDR     = "172.16.0.0/16"
+	ServiceCIDR = "172.20.0.0/16"
+)
+
 const OverlayNetworkPlan = `cluster:
   name: kubernetes
 
@@ -28,11 +39,11 @@ const OverlayNetworkPlan = `cluster:
 
     # Kubernetes will assign pods IPs in this range. Do not use a range that is
     # already in use on your local network!
-    pod_cidr_block: 172.16.0.0/16
+    pod_cidr_block: ` + PodCIDR + `
 
     # Kubernetes will assign services IPs in this range. Do not use a range
     # that is already in use by your local network or pod network!
-    service_cidr_block: 172.20.0.0/16
+    service_cidr_block: ` + ServiceCIDR + `
 
     # Set to true if your nodes cannot resolve each others' names using DNS.
     update_hosts_files: true
@@ -58,7 +69,7 @@ const OverlayNetworkPlan = `cluster:
 
     # Optional extra Subject Alternative Names (SANs) to use for the API Server serving certificate.
     # Can be both IP addresses and DNS names.
-    apiserver_cert_extra_sans: ""
+    apiserver_cert_extra_sans: "{{.APIServerCertExtraSANs}}"
 
   # SSH configuration for cluster nodes.
   ssh:
@@ -92,7 +103,7 @@ const OverlayNetworkPlan = `cluster:
     # Options: 'aws','azure','cloudstack','fake','gce','mesos','openstack',
     # 'ovirt','photon','rackspace','vsphere'.
     # Leave empty for bare metal setups or other unsupported providers.
-    provider: ""
+    provider: "{{.CloudProvider}}"
 
     # Path to the config file, leave empty if provider does not require it.
     config: ""
@@ -111,7 +122,7 @@ docker:
   storage:
 
     # Leave empty to have docker automatically select the driver.
-    driver: ""
+    driver: "{{if .DockerBlockDevice}}devicemapper{{end}}"
     opts: {}
 
     # Used for setting up Device Mapper storage driver in direct-lvm mode.
@@ -119,7 +130,7 @@ docker:
 
       # Absolute path to the block device that will be used for direct-lvm mode.
       # This device will be wiped and used exclusively by docker.
-      path: ""
+      path: "{{.DockerBlockDevice}}"
       thinpool_percent: "95"
       thinpool_metapercent: "1"
       thinpool_autoextend_threshold: "80"
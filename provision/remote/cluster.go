@@ -0,0 +1,71 @@
+package remote
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/apprenda/kismatic-provision/provision/plan"
+)
+
+// Cluster is the set of nodes that make up a cluster, grouped by role
+// (e.g. "etcd", "master", "worker").
+type Cluster map[string][]plan.Node
+
+var roleIndexSelector = regexp.MustCompile(`^([a-z]+)\[(\d+)\]$`)
+
+// Roles returns the roles in the cluster in alphabetical order
+func (c Cluster) Roles() []string {
+	roles := []string{}
+	for r := range c {
+		roles = append(roles, r)
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// Nodes returns every node in the cluster. A node that has more than one role
+// is only returned once.
+func (c Cluster) Nodes() []plan.Node {
+	seen := map[string]struct{}{}
+	nodes := []plan.Node{}
+	for _, r := range c.Roles() {
+		for _, n := range c[r] {
+			if _, ok := seen[n.ID+n.Host]; ok {
+				continue
+			}
+			seen[n.ID+n.Host] = struct{}{}
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+// Select returns the nodes that match the selector. The selector can be the hostname,
+// public IP or private IP of a node. It can also be the name of a role (e.g. "worker"),
+// which matches all the nodes in the role, or a role and a zero-based index
+// (e.g. "master[1]"), which matches a single node.
+func (c Cluster) Select(selector string) ([]plan.Node, error) {
+	if m := roleIndexSelector.FindStringSubmatch(selector); m != nil {
+		nodes, ok := c[m[1]]
+		if !ok {
+			return nil, fmt.Errorf("there are no %s nodes in the cluster", m[1])
+		}
+		i, _ := strconv.Atoi(m[2])
+		if i >= len(nodes) {
+			return nil, fmt.Errorf("%s is out of range: there are %d %s nodes in the cluster", selector, len(nodes), m[1])
+		}
+		return []plan.Node{nodes[i]}, nil
+	}
+	if nodes, ok := c[selector]; ok {
+		return nodes, nil
+	}
+	for _, n := range c.Nodes() {
+		if strings.EqualFold(n.Host, selector) || n.PublicIPv4 == selector || n.PrivateIPv4 == selector {
+			return []plan.Node{n}, nil
+		}
+	}
+	return nil, fmt.Errorf("no node matches %q. Use a hostname, IP address, role (%s) or role[index]", selector, strings.Join(c.Roles(), ", "))
+}
@@ -0,0 +1,204 @@
+package remote
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/apprenda/kismatic-provision/provision/plan"
+	"github.com/spf13/cobra"
+)
+
+// HookOpts are the options for the post-provision hooks that are run on
+// freshly provisioned nodes, before the plan file is generated.
+type HookOpts struct {
+	Scripts []string
+	Copies  []string
+	LogDir  string
+	Timeout time.Duration
+}
+
+// AddHookFlags adds the post-provision hook flags to the create command
+func AddHookFlags(cmd *cobra.Command, opts *HookOpts) {
+	cmd.Flags().StringArrayVar(&opts.Scripts, "post-provision", []string{}, "Script to run on the nodes once they are accessible via SSH. Prefix with a role to only run it on the nodes of that role (e.g. worker=setup.sh). Can be repeated.")
+	cmd.Flags().StringArrayVar(&opts.Copies, "copy", []string{}, "File to upload to the nodes once they are accessible via SSH, in the form local:remote. Prefix with a role to only upload it to the nodes of that role (e.g. master=ca.pem:/tmp/ca.pem). Can be repeated.")
+	cmd.Flags().StringVar(&opts.LogDir, "post-provision-logs", "post-provision-logs", "Directory where the output of the post-provision hooks is written, one file per node.")
+	cmd.Flags().DurationVar(&opts.Timeout, "post-provision-timeout", 15*time.Minute, "Maximum time to wait for each post-provision hook to complete on all nodes.")
+}
+
+// hookRoles are the roles that a hook can be limited to
+var hookRoles = []string{"etcd", "master", "worker", "bootstrap", "lb"}
+
+var rolePrefix = regexp.MustCompile(`^([a-z]+)=`)
+
+// Hook is a file that is uploaded to, or a script that is run on, the nodes of a role.
+// The hook applies to all the nodes in the cluster when the role is empty.
+type Hook struct {
+	Role   string
+	Script string
+	Local  string
+	Remote string
+}
+
+func (h Hook) String() string {
+	target := "all nodes"
+	if h.Role != "" {
+		target = h.Role + " nodes"
+	}
+	if h.Script != "" {
+		return fmt.Sprintf("running %s on %s", h.Script, target)
+	}
+	return fmt.Sprintf("copying %s to %s on %s", h.Local, h.Remote, target)
+}
+
+// Hooks returns the hooks defined in the options. All files are copied before
+// any script is run.
+func (opts HookOpts) Hooks() ([]Hook, error) {
+	hooks := []Hook{}
+	for _, c := range opts.Copies {
+		role, files := splitRole(c)
+		parts := strings.SplitN(files, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("%q is not a valid copy hook. Use the form [role=]local:remote", c)
+		}
+		if _, err := os.Stat(parts[0]); err != nil {
+			if err := unknownRole(c); err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("cannot copy %q: %v", parts[0], err)
+		}
+		hooks = append(hooks, Hook{Role: role, Local: parts[0], Remote: parts[1]})
+	}
+	for _, s := range opts.Scripts {
+		role, script := splitRole(s)
+		if _, err := os.Stat(script); err != nil {
+			if err := unknownRole(s); err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("cannot run post-provision script %q: %v", script, err)
+		}
+		hooks = append(hooks, Hook{Role: role, Script: script})
+	}
+	return hooks, nil
+}
+
+// splitRole splits the role off the value. Values that are not prefixed with a known
+// role are returned whole, as paths can contain "=".
+func splitRole(value string) (string, string) {
+	if m := rolePrefix.FindStringSubmatch(value); m != nil && isHookRole(m[1]) {
+		return m[1], value[len(m[0]):]
+	}
+	return "", value
+}
+
+// unknownRole returns an error if the value is prefixed with a role that is not known,
+// which is more likely to be a typo than part of a path that does not exist.
+func unknownRole(value string) error {
+	if m := rolePrefix.FindStringSubmatch(value); m != nil && !isHookRole(m[1]) {
+		return fmt.Errorf("unknown role %q in %q. Use one of %s", m[1], value, strings.Join(hookRoles, ", "))
+	}
+	return nil
+}
+
+func isHookRole(role string) bool {
+	for _, r := range hookRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// RunHooks runs the hooks against the nodes of the cluster. Each hook is run on all
+// its nodes in parallel, and must succeed on every node before the next hook is run.
+// The output of each node is appended to a file named after the node in the log directory.
+func (opts HookOpts) RunHooks(cluster Cluster, sshKey string) error {
+	hooks, err := opts.Hooks()
+	if err != nil {
+		return err
+	}
+	if len(hooks) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(opts.LogDir, 0755); err != nil {
+		return fmt.Errorf("error creating post-provision log directory: %v", err)
+	}
+	for _, h := range hooks {
+		nodes := cluster.Nodes()
+		if h.Role != "" {
+			var ok bool
+			if nodes, ok = cluster[h.Role]; !ok {
+				return fmt.Errorf("error %s: there are no %s nodes in the cluster", h, h.Role)
+			}
+		}
+		fmt.Printf("Post-provision: %s\n", h)
+		if err := runHook(h, nodes, sshKey, opts.LogDir, opts.Timeout); err != nil {
+			return fmt.Errorf("error %s: %v", h, err)
+		}
+	}
+	return nil
+}
+
+func runHook(h Hook, nodes []plan.Node, sshKey string, logDir string, period time.Duration) error {
+	timeout := time.After(period)
+	type result struct {
+		node plan.Node
+		err  error
+	}
+	results := make(chan result, len(nodes))
+	for _, n := range nodes {
+		go func(node plan.Node) {
+			var out string
+			var err error
+			if h.Script != "" {
+				dest := path.Join("/tmp", filepath.Base(h.Script))
+				out, err = ScpFile(h.Script, dest, node, sshKey)
+				if err == nil {
+					var cmdOut string
+					cmdOut, err = ExecuteCmd("bash "+dest, node, sshKey)
+					out = out + cmdOut
+				}
+			} else {
+				out, err = ScpFile(h.Local, h.Remote, node, sshKey)
+			}
+			if logErr := appendLog(logDir, node, h, out); logErr != nil {
+				fmt.Printf("Could not write post-provision log for %s: %v\n", node.Host, logErr)
+			}
+			results <- result{node: node, err: err}
+		}(n)
+	}
+
+	failed := []string{}
+	for range nodes {
+		select {
+		case r := <-results:
+			if r.err != nil {
+				failed = append(failed, r.node.Host)
+			}
+		case <-timeout:
+			return fmt.Errorf("timed out after %v", period)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed on %s. See the logs in %s", strings.Join(failed, ", "), logDir)
+	}
+	return nil
+}
+
+func appendLog(logDir string, node plan.Node, h Hook, out string) error {
+	name := node.Host
+	if name == "" {
+		name = node.PublicIPv4
+	}
+	f, err := os.OpenFile(filepath.Join(logDir, name+".log"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = fmt.Fprintf(f, "==> %s\n%s\n", h, out)
+	return err
+}
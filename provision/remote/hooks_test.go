@@ -0,0 +1,102 @@
+package remote
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitRole(t *testing.T) {
+	tests := []struct {
+		value string
+		role  string
+		rest  string
+	}{
+		{"setup.sh", "", "setup.sh"},
+		{"worker=setup.sh", "worker", "setup.sh"},
+		{"master=ca.pem:/tmp/ca.pem", "master", "ca.pem:/tmp/ca.pem"},
+		{"lb=haproxy.sh", "lb", "haproxy.sh"},
+		{"scripts/a=b.sh", "", "scripts/a=b.sh"},
+		{"key=value.sh", "", "key=value.sh"},
+		{"worker=a=b.sh", "worker", "a=b.sh"},
+	}
+	for _, test := range tests {
+		role, rest := splitRole(test.value)
+		if role != test.role || rest != test.rest {
+			t.Errorf("splitRole(%q) = %q, %q, expected %q, %q", test.value, role, rest, test.role, test.rest)
+		}
+	}
+}
+
+func TestHooks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hooks")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, "setup.sh")
+	withEquals := filepath.Join(dir, "a=b.sh")
+	for _, f := range []string{script, withEquals} {
+		if err := ioutil.WriteFile(f, []byte("true\n"), 0644); err != nil {
+			t.Fatalf("error writing %s: %v", f, err)
+		}
+	}
+
+	tests := []struct {
+		opts     HookOpts
+		expected []Hook
+		err      string
+	}{
+		{
+			opts:     HookOpts{Scripts: []string{script}},
+			expected: []Hook{{Script: script}},
+		},
+		{
+			opts:     HookOpts{Scripts: []string{"worker=" + script}},
+			expected: []Hook{{Role: "worker", Script: script}},
+		},
+		{
+			opts:     HookOpts{Scripts: []string{withEquals}},
+			expected: []Hook{{Script: withEquals}},
+		},
+		{
+			opts:     HookOpts{Scripts: []string{"master=" + script}, Copies: []string{"etcd=" + script + ":/tmp/setup.sh"}},
+			expected: []Hook{{Role: "etcd", Local: script, Remote: "/tmp/setup.sh"}, {Role: "master", Script: script}},
+		},
+		{
+			opts: HookOpts{Scripts: []string{"wroker=" + script}},
+			err:  `unknown role "wroker"`,
+		},
+		{
+			opts: HookOpts{Copies: []string{"mastr=" + script + ":/tmp/setup.sh"}},
+			err:  `unknown role "mastr"`,
+		},
+		{
+			opts: HookOpts{Copies: []string{script}},
+			err:  "not a valid copy hook",
+		},
+		{
+			opts: HookOpts{Scripts: []string{filepath.Join(dir, "missing.sh")}},
+			err:  "cannot run post-provision script",
+		},
+	}
+	for _, test := range tests {
+		hooks, err := test.opts.Hooks()
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%+v: expected error containing %q, got %v", test.opts, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%+v: unexpected error: %v", test.opts, err)
+			continue
+		}
+		if !reflect.DeepEqual(hooks, test.expected) {
+			t.Errorf("%+v: got %+v, expected %+v", test.opts, hooks, test.expected)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/apprenda/kismatic-provision/provision/plan"
+	"github.com/spf13/cobra"
+)
+
+// SplitArgs splits the arguments of an ssh command into the node selector
+// and the (optional) command that follows the "--" separator.
+func SplitArgs(cmd *cobra.Command, args []string) (string, []string, error) {
+	dash := cmd.ArgsLenAtDash()
+	if dash == -1 {
+		dash = len(args)
+	}
+	if dash != 1 {
+		return "", nil, errors.New("You must provide exactly one hostname, role or role[index] to connect to")
+	}
+	return args[0], args[dash:], nil
+}
+
+// SSH connects to the nodes of the cluster that match the selector. When no command is
+// given, an interactive session is opened, in which case the selector must match a
+// single node. Otherwise, the command is run on all the matching nodes in parallel.
+func SSH(cluster Cluster, selector string, command []string, sshKey string, timeout time.Duration) error {
+	nodes, err := cluster.Select(selector)
+	if err != nil {
+		return err
+	}
+	if len(command) == 0 {
+		if len(nodes) != 1 {
+			return fmt.Errorf("%q matches %d nodes. Provide a command to run on all of them, or select a single node", selector, len(nodes))
+		}
+		return Shell(nodes[0], sshKey)
+	}
+	if len(nodes) == 1 {
+		return Shell(nodes[0], sshKey, command...)
+	}
+	return RunViaSSH([]string{strings.Join(command, " ")}, nodes, sshKey, timeout)
+}
+
+// Shell runs an ssh session against the node that is attached to the current
+// terminal. If no command is provided, an interactive login shell is started.
+func Shell(node plan.Node, sshKey string, command ...string) error {
+	args := []string{"-o", "StrictHostKeyChecking no", "-t", "-i", sshKey}
+	args = append(args, ProxyArgs(node, sshKey)...)
+	args = append(args, node.SSHUser+"@"+node.PublicIPv4)
+	args = append(args, command...)
+	sshCmd := exec.Command("ssh", args...)
+	sshCmd.Stdin = os.Stdin
+	sshCmd.Stdout = os.Stdout
+	sshCmd.Stderr = os.Stderr
+	return sshCmd.Run()
+}
+
+// RunViaSSH runs the commands on all hosts in parallel. The commands are run
+// serially on each host, and the output of each command is printed out.
+func RunViaSSH(cmds []string, hosts []plan.Node, sshKey string, period time.Duration) error {
+	timeout := time.After(period)
+	bail := make(chan struct{})
+	cmdSuccess := make(chan bool)
+	// Create a goroutine per host. Each goroutine runs the commands serially on the host
+	// until one of these is true:
+	// a) all commands were executed successfully,
+	// b) an error occurred when running a command,
+	// c) the goroutine got a signal to bail
+	for _, host := range hosts {
+		go func(node plan.Node) {
+			for _, cmd := range cmds {
+				res, err := ExecuteCmd(cmd, node, sshKey)
+				fmt.Println(res)
+				select {
+				case cmdSuccess <- err == nil:
+					if err != nil {
+						return
+					}
+				case <-bail:
+					return
+				}
+			}
+		}(host)
+	}
+
+	// The bail channel is closed if we encounter an error, or if the timeout is reached.
+	// This will signal all goroutines to return.
+	defer close(bail)
+
+	// At most, we will get a total of hosts * cmds status messages in the channel.
+	for i := 0; i < len(hosts)*len(cmds); i++ {
+		select {
+		case ok := <-cmdSuccess:
+			if !ok {
+				return fmt.Errorf("error running command on node")
+			}
+		case <-timeout:
+			return fmt.Errorf("timed out running commands on nodes")
+		}
+	}
+	return nil
+}
+
+// ExecuteCmd runs the command on the node, and returns the combined output
+// prefixed with the address of the node.
+func ExecuteCmd(cmd string, node plan.Node, sshKey string) (string, error) {
+	args := []string{"-o", "StrictHostKeyChecking no", "-t", "-t", "-i", sshKey}
+	args = append(args, ProxyArgs(node, sshKey)...)
+	args = append(args, node.SSHUser+"@"+node.PublicIPv4, cmd)
+	sshOut, sshErr := exec.Command("ssh", args...).CombinedOutput()
+	return node.PublicIPv4 + ": " + string(sshOut), sshErr
+}
+
+// Output runs the command on the node without allocating a terminal, and returns
+// its standard output.
+func Output(node plan.Node, sshKey string, cmd string) (string, error) {
+	args := []string{"-o", "StrictHostKeyChecking no", "-o", "BatchMode yes", "-i", sshKey}
+	args = append(args, ProxyArgs(node, sshKey)...)
+	args = append(args, node.SSHUser+"@"+node.PublicIPv4, cmd)
+	out, err := exec.Command("ssh", args...).Output()
+	return string(out), err
+}
+
+// CopyFileToRemote copies the file to the destination path on the node.
+func CopyFileToRemote(file string, destFile string, node plan.Node, sshKey string, period time.Duration) error {
+	timeout := time.After(period)
+	success := make(chan bool)
+	go func() {
+		out, err := ScpFile(file, destFile, node, sshKey)
+		fmt.Println(out)
+		success <- err == nil
+	}()
+	select {
+	case ok := <-success:
+		if !ok {
+			return errors.New("failed to copy file to node")
+		}
+	case <-timeout:
+		return errors.New("timed out copying file to node")
+	}
+	return nil
+}
+
+// ScpFile copies the file to the destination path on the node, and returns
+// the combined output of scp.
+func ScpFile(filePath string, destFilePath string, node plan.Node, sshKey string) (string, error) {
+	args := []string{"-o", "StrictHostKeyChecking no", "-i", sshKey}
+	args = append(args, ProxyArgs(node, sshKey)...)
+	args = append(args, filePath, node.SSHUser+"@"+node.PublicIPv4+":"+destFilePath)
+	out, err := exec.Command("scp", args...).CombinedOutput()
+	return string(out), err
+}
+
+// ProxyArgs returns the ssh options that tunnel the connection to the node through
+// its bastion, if it has one. The bastion is reached with the same key and, unless
+// one is given, the same user as the node.
+func ProxyArgs(node plan.Node, sshKey string) []string {
+	if node.Bastion == "" {
+		return nil
+	}
+	bastion := node.Bastion
+	if !strings.Contains(bastion, "@") {
+		bastion = node.SSHUser + "@" + bastion
+	}
+	return []string{"-o", fmt.Sprintf("ProxyCommand=ssh -i %s -o StrictHostKeyChecking=no -o BatchMode=yes -W %%h:%%p %s", quote(sshKey), quote(bastion))}
+}
+
+// quote single-quotes a value for the shell that runs the ProxyCommand
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,37 @@
+package remote
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/apprenda/kismatic-provision/provision/plan"
+)
+
+func TestProxyArgs(t *testing.T) {
+	tests := []struct {
+		node     plan.Node
+		sshKey   string
+		expected []string
+	}{
+		{
+			node:   plan.Node{SSHUser: "ubuntu"},
+			sshKey: "key.pem",
+		},
+		{
+			node:     plan.Node{SSHUser: "ubuntu", Bastion: "10.0.0.1"},
+			sshKey:   "key.pem",
+			expected: []string{"-o", "ProxyCommand=ssh -i 'key.pem' -o StrictHostKeyChecking=no -o BatchMode=yes -W %h:%p 'ubuntu@10.0.0.1'"},
+		},
+		{
+			node:     plan.Node{SSHUser: "ubuntu", Bastion: "centos@bastion"},
+			sshKey:   "/home/me/my keys/it's.pem",
+			expected: []string{"-o", `ProxyCommand=ssh -i '/home/me/my keys/it'\''s.pem' -o StrictHostKeyChecking=no -o BatchMode=yes -W %h:%p 'centos@bastion'`},
+		},
+	}
+	for _, test := range tests {
+		args := ProxyArgs(test.node, test.sshKey)
+		if !reflect.DeepEqual(args, test.expected) {
+			t.Errorf("ProxyArgs(%v, %q) = %q, expected %q", test.node.Bastion, test.sshKey, args, test.expected)
+		}
+	}
+}
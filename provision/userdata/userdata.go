@@ -0,0 +1,113 @@
+package userdata
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cobra"
+)
+
+// roles are the roles that can be given their own user data
+var roles = []string{"etcd", "master", "worker", "bootstrap"}
+
+var rolePrefix = regexp.MustCompile(`^([a-z]+)=`)
+
+// Opts are the user data (e.g. cloud-init) options of the create commands
+type Opts struct {
+	Files []string
+}
+
+// Data is made available to user data templates.
+// e.g. hostnamectl set-hostname {{.ClusterName}}-{{.Role}}-{{.Index}}
+type Data struct {
+	ClusterName string
+	Role        string
+	// Index is the zero-based index of the node within its role
+	Index int
+}
+
+// AddFlags adds the user data flags to the create command
+func AddFlags(cmd *cobra.Command, opts *Opts) {
+	cmd.Flags().StringArrayVar(&opts.Files, "user-data", []string{}, "File passed as user data (e.g. a cloud-init config or a shell script) to the nodes when they are created. Prefix with a role to use a different file for the nodes of that role (e.g. worker=worker-init.sh). The file is a template that can reference {{.ClusterName}}, {{.Role}} and {{.Index}}. Can be repeated.")
+}
+
+// Validate checks that all user data files exist and are valid templates
+func (opts Opts) Validate() error {
+	_, err := opts.Templates()
+	return err
+}
+
+// Templates are the parsed user data files, keyed by role. The empty role is for
+// the nodes of all roles.
+type Templates map[string]*template.Template
+
+// Render returns the user data for the node with the given role and index, or an
+// empty string if no user data was requested for the role. The file given for the
+// role takes precedence over the file given for all roles.
+func (templates Templates) Render(clusterName, role string, index int) (string, error) {
+	t, ok := templates[role]
+	if !ok {
+		if t, ok = templates[""]; !ok {
+			return "", nil
+		}
+	}
+	var b bytes.Buffer
+	data := Data{
+		ClusterName: clusterName,
+		Role:        role,
+		Index:       index,
+	}
+	if err := t.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("error rendering user data for %s node %d: %v", role, index, err)
+	}
+	return b.String(), nil
+}
+
+// Templates reads and parses the user data files, which should be done once per cluster
+func (opts Opts) Templates() (Templates, error) {
+	templates := Templates{}
+	for _, f := range opts.Files {
+		role, file := splitRole(f)
+		if _, ok := templates[role]; ok {
+			if role == "" {
+				return nil, fmt.Errorf("user data for all roles was provided more than once")
+			}
+			return nil, fmt.Errorf("user data for the %s role was provided more than once", role)
+		}
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			if m := rolePrefix.FindStringSubmatch(f); m != nil && !isRole(m[1]) {
+				return nil, fmt.Errorf("unknown role %q in user data %q. Use one of %s", m[1], f, strings.Join(roles, ", "))
+			}
+			return nil, fmt.Errorf("error reading user data file: %v", err)
+		}
+		t, err := template.New(file).Option("missingkey=error").Parse(string(b))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing user data file %q: %v", file, err)
+		}
+		templates[role] = t
+	}
+	return templates, nil
+}
+
+// splitRole splits the role off the user data file. Files that are not prefixed with a
+// known role are returned whole, as paths can contain "=".
+func splitRole(file string) (string, string) {
+	if m := rolePrefix.FindStringSubmatch(file); m != nil && isRole(m[1]) {
+		return m[1], file[len(m[0]):]
+	}
+	return "", file
+}
+
+func isRole(role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
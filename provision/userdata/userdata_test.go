@@ -0,0 +1,114 @@
+package userdata
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	dir, err := ioutil.TempDir("", "userdata")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"all.sh":    "all {{.ClusterName}}-{{.Role}}-{{.Index}}",
+		"worker.sh": "worker {{.Index}}",
+		"a=b.sh":    "equals",
+		"bad.sh":    "{{.Missing",
+		"key.sh":    "{{.NotAField}}",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("error writing %s: %v", name, err)
+		}
+	}
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	tests := []struct {
+		files    []string
+		role     string
+		index    int
+		expected string
+		err      string
+	}{
+		{
+			role: "worker",
+		},
+		{
+			files:    []string{path("all.sh")},
+			role:     "master",
+			index:    1,
+			expected: "all test-master-1",
+		},
+		{
+			files:    []string{path("all.sh"), "worker=" + path("worker.sh")},
+			role:     "worker",
+			index:    2,
+			expected: "worker 2",
+		},
+		{
+			files:    []string{path("all.sh"), "worker=" + path("worker.sh")},
+			role:     "etcd",
+			expected: "all test-etcd-0",
+		},
+		{
+			files: []string{"worker=" + path("worker.sh")},
+			role:  "etcd",
+		},
+		{
+			files:    []string{path("a=b.sh")},
+			role:     "worker",
+			expected: "equals",
+		},
+		{
+			files: []string{"wroker=" + path("worker.sh")},
+			err:   `unknown role "wroker"`,
+		},
+		{
+			files: []string{path("all.sh"), path("worker.sh")},
+			err:   "provided more than once",
+		},
+		{
+			files: []string{"worker=" + path("all.sh"), "worker=" + path("worker.sh")},
+			err:   "worker role was provided more than once",
+		},
+		{
+			files: []string{path("missing.sh")},
+			err:   "error reading user data file",
+		},
+		{
+			files: []string{path("bad.sh")},
+			err:   "error parsing user data file",
+		},
+		{
+			files: []string{path("key.sh")},
+			role:  "worker",
+			err:   "error rendering user data for worker node 0",
+		},
+	}
+	for _, test := range tests {
+		opts := Opts{Files: test.files}
+		templates, err := opts.Templates()
+		var ud string
+		if err == nil {
+			ud, err = templates.Render("test", test.role, test.index)
+		}
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%v: expected error containing %q, got %v", test.files, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", test.files, err)
+			continue
+		}
+		if ud != test.expected {
+			t.Errorf("%v: rendered %q for %s node %d, expected %q", test.files, ud, test.role, test.index, test.expected)
+		}
+	}
+}
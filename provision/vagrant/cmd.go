@@ -3,6 +3,9 @@ package vagrant
 import (
 	"fmt"
 
+	"github.com/apprenda/kismatic-provision/provision/install"
+	"github.com/apprenda/kismatic-provision/provision/lb"
+	"github.com/apprenda/kismatic-provision/provision/plan"
 	"github.com/apprenda/kismatic-provision/provision/utils"
 	"github.com/spf13/cobra"
 )
@@ -11,6 +14,7 @@ type VagrantCmdOpts struct {
 	PlanOpts
 	NoPlan                  bool
 	OnlyGenerateVagrantfile bool
+	Install                 install.Opts
 }
 
 func Cmd() *cobra.Command {
@@ -52,10 +56,12 @@ func AddSharedFlags(cmd *cobra.Command, opts *VagrantCmdOpts) {
 	// (*cmd).Flags().BoolVar(&opts.OnlyGenerateVagrantfile, "onlyGenerateVagrantFile", false, "If present, forgoes performing `vagrant up` on the generated Vagrantfile")
 	(*cmd).Flags().BoolVar(&opts.NoPlan, "noplan", false, "If present, foregoes generating a plan file in this directory referencing the newly created nodes")
 	(*cmd).Flags().BoolVarP(&opts.Storage, "storage-cluster", "s", false, "Create a storage cluster from all Worker nodes.")
+	install.AddFlags(cmd, &opts.Install)
 }
 
 func VagrantCreateCmd() *cobra.Command {
 	var etcdCount, masterCount, workerCount, ingressCount uint16
+	var loadBalancer bool
 
 	opts := VagrantCmdOpts{
 		PlanOpts: PlanOpts{
@@ -76,6 +82,9 @@ until the instances are all online and accessible via SSH.`,
 			opts.Count[Master] = masterCount
 			opts.Count[Worker] = workerCount
 			opts.Count[Ingress] = ingressCount
+			if loadBalancer {
+				opts.Count[LoadBalancer] = 1
+			}
 			return makeInfrastructure(&opts)
 		},
 	}
@@ -83,6 +92,7 @@ until the instances are all online and accessible via SSH.`,
 	cmd.Flags().Uint16VarP(&etcdCount, "etcdNodeCount", "e", 1, "Count of etcd nodes to produce.")
 	cmd.Flags().Uint16VarP(&masterCount, "masterdNodeCount", "m", 1, "Count of master nodes to produce.")
 	cmd.Flags().Uint16VarP(&workerCount, "workerNodeCount", "w", 1, "Count of worker nodes to produce.")
+	cmd.Flags().BoolVar(&loadBalancer, "lb", false, "Create a load balancer node running HAProxy in front of the master nodes, and use it as the load balancer of the plan.")
 	// cmd.Flags().Uint16VarP(&ingressCount, "ingressNodeCount", "i", 1, "Count of ingress nodes to produce")
 	// cmd.Flags().BoolVar(&opts.OverlapRoles, "overlapRoles", false, "Overlap roles to create as few nodes as possible")
 
@@ -123,6 +133,13 @@ A smallish instance will be created with public IP addresses. The command will n
 }
 
 func makeInfrastructure(opts *VagrantCmdOpts) error {
+	if opts.Install.Enabled && opts.NoPlan {
+		return fmt.Errorf("--install cannot be used with --noplan")
+	}
+	if err := opts.Install.Validate(); err != nil {
+		return err
+	}
+
 	infrastructure, infraErr := NewInfrastructure(&opts.InfrastructureOpts)
 	if infraErr != nil {
 		return infraErr
@@ -144,12 +161,21 @@ func makeInfrastructure(opts *VagrantCmdOpts) error {
 
 	infrastructure.PrivateSSHKeyPath = grabSSHConfig()
 
+	if !opts.OnlyGenerateVagrantfile {
+		if err := configureLoadBalancer(infrastructure); err != nil {
+			return err
+		}
+	}
+
 	if !opts.NoPlan {
 		planFile, planErr := createPlan(opts, infrastructure)
 		if planErr != nil {
 			return planErr
 		}
 
+		if opts.Install.Enabled {
+			return opts.Install.Run(planFile)
+		}
 		fmt.Println("To install your cluster, run:")
 		fmt.Println("./kismatic install apply -f " + planFile)
 	}
@@ -157,6 +183,19 @@ func makeInfrastructure(opts *VagrantCmdOpts) error {
 	return nil
 }
 
+// configureLoadBalancer sets up HAProxy on the load balancer node, if there is one
+func configureLoadBalancer(infrastructure *Infrastructure) error {
+	lbs := infrastructure.nodesByType(LoadBalancer)
+	if len(lbs) == 0 {
+		return nil
+	}
+	masters := []plan.Node{}
+	for _, m := range infrastructure.nodesByType(Master) {
+		masters = append(masters, m.planNode())
+	}
+	return lb.Configure(lbs[0].planNode(), infrastructure.PrivateSSHKeyPath, masters)
+}
+
 func createVagrantfile(opts *VagrantCmdOpts, infrastructure *Infrastructure) (string, error) {
 	vagrantfile, err := utils.MakeFileAskOnOverwrite("Vagrantfile")
 	if err != nil {
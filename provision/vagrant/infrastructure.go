@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/apprenda/kismatic-provision/provision/plan"
 	"github.com/apprenda/kismatic-provision/provision/utils"
 )
 
@@ -17,15 +18,17 @@ const (
 	Master
 	Worker
 	Ingress
+	LoadBalancer
 )
 
-var NodeTypes = []NodeType{Etcd, Master, Worker, Ingress}
+var NodeTypes = []NodeType{Etcd, Master, Worker, Ingress, LoadBalancer}
 
 var NodeTypeStrings = map[NodeType]string{
-	Etcd:    "etcd",
-	Master:  "master",
-	Worker:  "worker",
-	Ingress: "ingress",
+	Etcd:         "etcd",
+	Master:       "master",
+	Worker:       "worker",
+	Ingress:      "ingress",
+	LoadBalancer: "lb",
 }
 
 type InfrastructureOpts struct {
@@ -85,7 +88,8 @@ func NewInfrastructure(opts *InfrastructureOpts) (*Infrastructure, error) {
 
 		for _, nodeType := range NodeTypes {
 			if j <= opts.Count[nodeType] {
-				if opts.OverlapRoles {
+				// the load balancer listens on the API server port, so it cannot share a node with a master
+				if opts.OverlapRoles && nodeType != LoadBalancer {
 					overlapTypes |= nodeType
 				} else {
 					_, err := i.appendNode(j, NodeTypeStrings[nodeType], nodeType)
@@ -196,6 +200,16 @@ func (i *Infrastructure) nextNodeIP() (net.IP, error) {
 	return ip, nil
 }
 
+// planNode returns the node as seen by the SSH helpers
+func (n NodeDetails) planNode() plan.Node {
+	return plan.Node{
+		Host:        n.Name,
+		PublicIPv4:  n.IP.String(),
+		PrivateIPv4: n.IP.String(),
+		SSHUser:     "vagrant",
+	}
+}
+
 func (i *Infrastructure) nodesByType(nodeType NodeType) []NodeDetails {
 	filtered := []NodeDetails{}
 	for _, node := range i.Nodes {
@@ -55,6 +55,23 @@ func (p *Plan) Ingress() []NodeDetails {
 	return p.Infrastructure.nodesByType(Worker)[0:1]
 }
 
+// LoadBalancer returns the address of the load balancer node if there is one,
+// or the address of the first master.
+func (p *Plan) LoadBalancer() string {
+	if lbs := p.Infrastructure.nodesByType(LoadBalancer); len(lbs) > 0 {
+		return lbs[0].IP.String() + ":6443"
+	}
+	return p.Master()[0].IP.String() + ":6443"
+}
+
+// APIServerCertExtraSANs returns the address of the load balancer node, if there is one
+func (p *Plan) APIServerCertExtraSANs() string {
+	if lbs := p.Infrastructure.nodesByType(LoadBalancer); len(lbs) > 0 {
+		return lbs[0].IP.String()
+	}
+	return ""
+}
+
 func (p *Plan) Storage() []NodeDetails {
 	if p.Opts.Storage {
 		return p.Infrastructure.nodesByType(Worker)
@@ -109,7 +126,7 @@ const planVagrantOverlay = `cluster:
 
     # Optional extra Subject Alternative Names (SANs) to use for the API Server serving certificate.
     # Can be both IP addresses and DNS names.
-    apiserver_cert_extra_sans: ""
+    apiserver_cert_extra_sans: "{{.APIServerCertExtraSANs}}"
 
   # SSH configuration for cluster nodes.
   ssh:
@@ -302,7 +319,7 @@ master:
 
   # If you have set up load balancing for master nodes, enter the IP or DNS and Port.
   # Otherwise, use the IP address of a single master node and port '6443'.
-  load_balancer: {{with index .Master 0 }}{{.IP.String}}:6443{{end}}
+  load_balancer: {{.LoadBalancer}}
   expected_count: {{len .Master}}
   nodes:{{range .Master}}
   - host: {{.Name}}
@@ -0,0 +1,259 @@
+package validate
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apprenda/kismatic-provision/provision/plan"
+	"github.com/apprenda/kismatic-provision/provision/remote"
+)
+
+// maxClockSkew is the largest difference allowed between the clock of a node and
+// the clock of the machine running provision
+const maxClockSkew = time.Minute
+
+type requirements struct {
+	CPUs     int
+	MemoryMB int
+	DiskGB   int
+}
+
+// minimums are the resources required by each role. Memory is compared against
+// MemTotal, which is lower than the installed memory, so a 1GB machine passes.
+var minimums = map[string]requirements{
+	"etcd":   {CPUs: 1, MemoryMB: 900, DiskGB: 10},
+	"master": {CPUs: 1, MemoryMB: 900, DiskGB: 10},
+	"worker": {CPUs: 1, MemoryMB: 1800, DiskGB: 10},
+}
+
+// ports are the ports each role must accept connections on from the other nodes
+var ports = map[string][]int{
+	"etcd":   {2379, 2380, 6660, 6666},
+	"master": {6443, 10250},
+	"worker": {10250},
+}
+
+// probe gathers the facts needed by the checks. Nothing is listening on the ports
+// before KET is installed, so a refused connection counts as reachable, and only
+// a timeout, which is what a firewall dropping the packets looks like, is reported.
+const probe = `. /etc/os-release 2>/dev/null
+echo "os=$ID $VERSION_ID"
+echo "cpus=$(nproc)"
+echo "memory=$(awk '/MemTotal/ {print $2}' /proc/meminfo)"
+echo "disk=$(df -Pk / | awk 'NR==2 {print $2}')"
+echo "swap=$(tail -n +2 /proc/swaps | wc -l)"
+echo "python=$(command -v python2 || command -v python)"
+echo "hostname=$(hostname)"
+echo "fqdn=$(hostname -f 2>/dev/null)"
+echo "resolves=$(getent hosts $(hostname) | awk '{print $1; exit}')"
+echo "time=$(date +%s)"
+echo "ntp=$(timedatectl status 2>/dev/null | awk -F': ' '/synchronized/ {print $2; exit}')"
+probe() {
+  timeout 3 bash -c "</dev/tcp/$1/$2" 2>/dev/null
+  echo "port=$1:$2 $?"
+}
+`
+
+type facts struct {
+	values map[string]string
+	ports  map[string]bool
+}
+
+func checkNode(node plan.Node, roles []string, cluster remote.Cluster, sshKey string) ([]Result, error) {
+	script := probe
+	targets := portTargets(node, cluster)
+	for _, t := range targets {
+		parts := strings.SplitN(t, ":", 2)
+		script += fmt.Sprintf("probe %s %s\n", parts[0], parts[1])
+	}
+	cmd := fmt.Sprintf("echo %s | base64 -d | bash", base64.StdEncoding.EncodeToString([]byte(script)))
+	start := time.Now()
+	out, err := remote.Output(node, sshKey, cmd)
+	if err != nil {
+		return nil, fmt.Errorf("error running checks: %v", err)
+	}
+	f := parseFacts(out)
+
+	req := requirements{}
+	for _, r := range roles {
+		m := minimums[r]
+		if m.CPUs > req.CPUs {
+			req.CPUs = m.CPUs
+		}
+		if m.MemoryMB > req.MemoryMB {
+			req.MemoryMB = m.MemoryMB
+		}
+		if m.DiskGB > req.DiskGB {
+			req.DiskGB = m.DiskGB
+		}
+	}
+
+	return []Result{
+		checkOS(f.values["os"]),
+		checkMinimum("cpu", f.values["cpus"], 1, req.CPUs, "CPUs"),
+		checkMinimum("memory", f.values["memory"], 1024, req.MemoryMB, "MB"),
+		checkMinimum("disk", f.values["disk"], 1024*1024, req.DiskGB, "GB"),
+		checkSwap(f.values["swap"]),
+		checkPython(f.values["python"]),
+		checkHostname(node, f.values["hostname"], f.values["fqdn"]),
+		checkResolution(node, f.values["hostname"], f.values["resolves"]),
+		checkTime(f.values["time"], f.values["ntp"], start),
+		checkPorts(targets, f.ports),
+	}, nil
+}
+
+// portTargets returns the address:port pairs the node must be able to reach
+func portTargets(node plan.Node, cluster remote.Cluster) []string {
+	targets := []string{}
+	seen := map[string]bool{}
+	for _, role := range cluster.Roles() {
+		for _, n := range cluster[role] {
+			if n.ID == node.ID && n.Host == node.Host {
+				continue
+			}
+			ip := n.PrivateIPv4
+			if ip == "" {
+				ip = n.PublicIPv4
+			}
+			for _, p := range ports[role] {
+				t := ip + ":" + strconv.Itoa(p)
+				if !seen[t] {
+					seen[t] = true
+					targets = append(targets, t)
+				}
+			}
+		}
+	}
+	return targets
+}
+
+func parseFacts(out string) facts {
+	f := facts{values: map[string]string{}, ports: map[string]bool{}}
+	s := bufio.NewScanner(strings.NewReader(out))
+	for s.Scan() {
+		parts := strings.SplitN(strings.TrimSpace(s.Text()), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if parts[0] == "port" {
+			p := strings.Fields(parts[1])
+			if len(p) == 2 {
+				// timeout exits with 124 when the connection could not be made in time
+				f.ports[p[0]] = p[1] != "124"
+			}
+			continue
+		}
+		f.values[parts[0]] = parts[1]
+	}
+	return f
+}
+
+func checkOS(release string) Result {
+	res := Result{Check: "os", Detail: release}
+	fields := strings.Fields(release)
+	if len(fields) != 2 {
+		res.Detail = "unable to determine the operating system"
+		return res
+	}
+	switch fields[0] {
+	case "ubuntu":
+		res.Success = fields[1] == "16.04"
+	case "centos", "rhel":
+		res.Success = strings.HasPrefix(fields[1], "7")
+	}
+	if !res.Success {
+		res.Detail = release + " is not supported by KET"
+	}
+	return res
+}
+
+// checkMinimum verifies that the value, once divided by unit, is at least min
+func checkMinimum(check string, value string, unit int, min int, suffix string) Result {
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		return Result{Check: check, Detail: fmt.Sprintf("unable to parse %q", value)}
+	}
+	v = v / unit
+	return Result{
+		Check:   check,
+		Success: v >= min,
+		Detail:  fmt.Sprintf("%d %s (minimum %d)", v, suffix, min),
+	}
+}
+
+func checkSwap(swap string) Result {
+	if swap == "0" {
+		return Result{Check: "swap", Success: true, Detail: "disabled"}
+	}
+	return Result{Check: "swap", Detail: "swap is enabled, the kubelet will not start"}
+}
+
+func checkPython(python string) Result {
+	if python == "" {
+		return Result{Check: "python", Detail: "python is not installed"}
+	}
+	return Result{Check: "python", Success: true, Detail: python}
+}
+
+func checkHostname(node plan.Node, hostname string, fqdn string) Result {
+	res := Result{Check: "hostname", Detail: hostname}
+	host := strings.ToLower(node.Host)
+	switch {
+	case node.Host == "":
+		res.Success = true
+	case strings.ToLower(hostname) == host, strings.ToLower(fqdn) == host:
+		res.Success = true
+	case hostname != "" && strings.HasPrefix(host, strings.ToLower(hostname)+"."):
+		res.Success = true
+	default:
+		res.Detail = fmt.Sprintf("%q does not match %q in the plan", hostname, node.Host)
+	}
+	return res
+}
+
+func checkResolution(node plan.Node, hostname string, ip string) Result {
+	res := Result{Check: "dns", Detail: fmt.Sprintf("%s resolves to %s", hostname, ip)}
+	switch {
+	case ip == "":
+		res.Detail = fmt.Sprintf("%s does not resolve", hostname)
+	case ip == node.PrivateIPv4, ip == node.PublicIPv4, strings.HasPrefix(ip, "127."):
+		res.Success = true
+	}
+	return res
+}
+
+func checkTime(epoch string, ntp string, start time.Time) Result {
+	res := Result{Check: "time"}
+	t, err := strconv.ParseInt(epoch, 10, 64)
+	if err != nil {
+		res.Detail = fmt.Sprintf("unable to parse %q", epoch)
+		return res
+	}
+	skew := time.Unix(t, 0).Sub(start)
+	if skew < 0 {
+		skew = -skew
+	}
+	if ntp == "" {
+		ntp = "unknown"
+	}
+	res.Success = skew <= maxClockSkew
+	res.Detail = fmt.Sprintf("clock skew %s, synchronized: %s", skew.Truncate(time.Second), ntp)
+	return res
+}
+
+func checkPorts(targets []string, reachable map[string]bool) Result {
+	unreachable := []string{}
+	for _, t := range targets {
+		if !reachable[t] {
+			unreachable = append(unreachable, t)
+		}
+	}
+	if len(unreachable) > 0 {
+		return Result{Check: "ports", Detail: "unreachable: " + strings.Join(unreachable, ", ")}
+	}
+	return Result{Check: "ports", Success: true, Detail: fmt.Sprintf("%d ports reachable", len(targets))}
+}
@@ -0,0 +1,130 @@
+package validate
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/apprenda/kismatic-provision/provision/plan"
+	"github.com/apprenda/kismatic-provision/provision/remote"
+	"github.com/spf13/cobra"
+)
+
+// Opts are the options for validating the nodes before the cluster is installed
+type Opts struct {
+	Enabled bool
+	Strict  bool
+}
+
+// AddFlags adds the validation flags to the create command
+func AddFlags(cmd *cobra.Command, opts *Opts) {
+	cmd.Flags().BoolVar(&opts.Enabled, "validate", false, "If present, checks that the nodes are ready for KET once they are accessible via SSH, and prints a report for each node.")
+	cmd.Flags().BoolVar(&opts.Strict, "strict", false, "If present, the command fails when a node does not pass validation. Implies --validate.")
+}
+
+// Result is the outcome of a single check on a node
+type Result struct {
+	Check   string
+	Success bool
+	Detail  string
+}
+
+// Report holds the results of all the checks on a node
+type Report struct {
+	Node    plan.Node
+	Roles   []string
+	Results []Result
+	Err     error
+}
+
+// Success returns true if the node passed all checks
+func (r Report) Success() bool {
+	if r.Err != nil {
+		return false
+	}
+	for _, res := range r.Results {
+		if !res.Success {
+			return false
+		}
+	}
+	return true
+}
+
+// Run validates the nodes of the cluster, if requested, and prints out a report for each
+// node. An error is returned if a node failed validation in strict mode.
+func (opts Opts) Run(cluster remote.Cluster, sshKey string) error {
+	if !opts.Enabled && !opts.Strict {
+		return nil
+	}
+	fmt.Println("Validating nodes")
+	reports := Nodes(cluster, sshKey)
+	Print(reports)
+	for _, r := range reports {
+		if !r.Success() {
+			if opts.Strict {
+				return errors.New("one or more nodes failed validation")
+			}
+			fmt.Println("WARNING: one or more nodes failed validation. KET may fail to install the cluster.")
+			return nil
+		}
+	}
+	return nil
+}
+
+// Nodes validates all the etcd, master and worker nodes of the cluster in parallel.
+// Other roles, such as the bootstrap node, are not part of the plan and are not validated.
+func Nodes(cluster remote.Cluster, sshKey string) []Report {
+	reports := []Report{}
+	byKey := map[string]int{}
+	for _, role := range cluster.Roles() {
+		if _, ok := minimums[role]; !ok {
+			continue
+		}
+		for _, n := range cluster[role] {
+			key := n.ID + n.Host
+			if i, ok := byKey[key]; ok {
+				reports[i].Roles = append(reports[i].Roles, role)
+				continue
+			}
+			byKey[key] = len(reports)
+			reports = append(reports, Report{Node: n, Roles: []string{role}})
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := range reports {
+		wg.Add(1)
+		go func(r *Report) {
+			defer wg.Done()
+			r.Results, r.Err = checkNode(r.Node, r.Roles, cluster, sshKey)
+		}(&reports[i])
+	}
+	wg.Wait()
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].Node.Host < reports[j].Node.Host
+	})
+	return reports
+}
+
+// Print writes out the report of each node
+func Print(reports []Report) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, r := range reports {
+		fmt.Fprintf(w, "%s (%s) %v\n", r.Node.Host, r.Node.PublicIPv4, r.Roles)
+		if r.Err != nil {
+			fmt.Fprintf(w, "  FAIL\tssh\t%v\n", r.Err)
+			continue
+		}
+		for _, res := range r.Results {
+			status := "OK"
+			if !res.Success {
+				status = "FAIL"
+			}
+			fmt.Fprintf(w, "  %s\t%s\t%s\n", status, res.Check, res.Detail)
+		}
+	}
+	w.Flush()
+}